@@ -20,26 +20,38 @@ import (
 	"github.com/joho/godotenv"
 
 	chatshandler "github.com/kgellert/hodatay-messenger/internal/chats/handler"
+	chatspolicy "github.com/kgellert/hodatay-messenger/internal/chats/policy"
 	chatsrepo "github.com/kgellert/hodatay-messenger/internal/chats/repo"
 	appConfig "github.com/kgellert/hodatay-messenger/internal/config"
 	configHandler "github.com/kgellert/hodatay-messenger/internal/config/handler"
 	"github.com/kgellert/hodatay-messenger/internal/logger"
 	"github.com/kgellert/hodatay-messenger/internal/logger/handlers/slogpretty"
 	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
+	mattershandler "github.com/kgellert/hodatay-messenger/internal/matters/handler"
+	mattersrepo "github.com/kgellert/hodatay-messenger/internal/matters/repo"
 	messageshandler "github.com/kgellert/hodatay-messenger/internal/messages/handler"
 	messagesrepo "github.com/kgellert/hodatay-messenger/internal/messages/repo"
+	"github.com/kgellert/hodatay-messenger/internal/presence"
+	sessionsrepo "github.com/kgellert/hodatay-messenger/internal/sessions/repo"
+	sessionsservice "github.com/kgellert/hodatay-messenger/internal/sessions/service"
 	uploadshandler "github.com/kgellert/hodatay-messenger/internal/uploads/handler"
 	uploadsrepo "github.com/kgellert/hodatay-messenger/internal/uploads/repo"
 	uploadsservice "github.com/kgellert/hodatay-messenger/internal/uploads/service"
 	userhandlers "github.com/kgellert/hodatay-messenger/internal/users/handlers"
 	usersrepo "github.com/kgellert/hodatay-messenger/internal/users/repo"
+	"github.com/kgellert/hodatay-messenger/internal/ws/eventlog"
 	ws "github.com/kgellert/hodatay-messenger/internal/ws/handler"
 	"github.com/kgellert/hodatay-messenger/internal/ws/hub"
+	"github.com/kgellert/hodatay-messenger/internal/ws/pgnotify"
 )
 
 const (
 	envLocal = "local"
 	envDev   = "dev"
+
+	chatEventsCleanupInterval = time.Hour
+	// Отметка онлайна упавшего инстанса снимается через три пропущенных раза
+	presenceHeartbeatInterval = 30 * time.Second
 )
 
 func main() {
@@ -63,9 +75,6 @@ func main() {
 	router.Use(middleware.Recoverer)
 	router.Use(middleware.URLFormat)
 
-	h := hub.NewHub()
-	go h.Run()
-
 	bucket := os.Getenv("S3_BUCKET")
 	region := os.Getenv("S3_REGION")
 	endpoint := os.Getenv("S3_ENDPOINT")
@@ -97,22 +106,40 @@ func main() {
 		os.Exit(1)
 	}
 
+	h := hub.NewHub(newWSBackend(cfg, db, log), log)
+
 	usersRepo := usersrepo.New(db)
-	chatsRepo := chatsrepo.New(db, usersRepo)
 	messagesRepo := messagesrepo.New(db)
+	presenceStore := presence.NewStore(db, h.ID(), 3*presenceHeartbeatInterval)
+	chatsRepo := chatsrepo.New(db, presence.WithOnline(usersRepo, presenceStore), messagesRepo)
 	uploadsRepo := uploadsrepo.New(db)
+	sessionsRepo := sessionsrepo.New(db)
+	mattersRepo := mattersrepo.New(db)
 
 	uploadsService := uploadsservice.New(bucket, presigner, s3Client, uploadsRepo, cfg.Uploads.PresignTTL)
+	sessionsService := sessionsservice.New(sessionsRepo, cfg.Auth.SessionTTL)
+	chatsPolicy := chatspolicy.New(chatsRepo)
+
+	presenceService := presence.New(h, presenceStore, chatsRepo, usersRepo, log)
+	h.SetPresenceListener(presenceService)
+	go h.Run()
+	go presenceService.RunHeartbeat(ctx, presenceHeartbeatInterval)
+
+	eventLog := eventlog.New(db, h, cfg.WS.EventRetention, cfg.WS.ReplayLimit, log)
+	go eventLog.RunCleanup(ctx, chatEventsCleanupInterval)
 
 	configHandler := configHandler.New(*cfg, log)
-	usersHandler := userhandlers.New(usersRepo, log)
-	chatsHandler := chatshandler.New(chatsRepo, log)
+	usersHandler := userhandlers.New(usersRepo, sessionsService, h, cfg.Auth, log)
+	chatsHandler := chatshandler.New(chatsRepo, chatsPolicy, messagesRepo, eventLog, h, log)
 	messagesHandler := messageshandler.New(
 		messagesRepo,
 		uploadsService,
-		h,
+		eventLog,
+		chatsPolicy,
+		cfg.Messages,
 		log,
 	)
+	mattersHandler := mattershandler.New(mattersRepo, log)
 	uploadsHandler := uploadshandler.New(
 		uploadsService,
 		log,
@@ -123,22 +150,67 @@ func main() {
 	router.Post("/signin", usersHandler.SignInHandler())
 
 	router.Group(func(r chi.Router) {
-		r.Use(userhandlers.WithUser)
+		r.Use(usersHandler.WithUser)
+
+		r.Post("/session/refresh", usersHandler.RefreshSession())
+		r.Post("/signout", usersHandler.SignOut())
+		r.Post("/signout/all", usersHandler.SignOutAll())
+
+		r.Group(func(r chi.Router) {
+			r.Use(usersHandler.RequireAdmin)
+
+			r.Post("/admin/users", usersHandler.CreateUser())
+			r.Patch("/admin/users/{userId}", usersHandler.UpdateUser())
+			r.Post("/admin/users/{userId}/disable", usersHandler.DisableUser())
+			r.Get("/admin/ws/connections", ws.StatsHandler(h, log))
+			// Поручения ведут админы; остальные создают их и привязывают чаты
+			r.Patch("/matters/{matterId}", mattersHandler.UpdateMatter())
+			r.Delete("/matters/{matterId}", mattersHandler.DeleteMatter())
+		})
+
+		r.Post("/matters", mattersHandler.CreateMatter())
+		r.Get("/matters", mattersHandler.GetMatters())
+		r.Get("/matters/{matterId}", mattersHandler.GetMatter())
+		r.Get("/matters/{matterId}/chats", chatsHandler.GetMatterChats())
 
 		r.Post("/chats", chatsHandler.CreateChat())
+		r.Post("/chats/direct", chatsHandler.GetOrCreateDirectChat())
 		r.Get("/chats", chatsHandler.GetChats())
-		r.Get("/chats/{chatId}", chatsHandler.GetChat())
 		r.Get("/chats/stats/unread-count", chatsHandler.GetUnreadMessagesCount())
-		r.Delete("/chats/{chatId}", chatsHandler.DeleteChat())
 		r.Post("/chats/deleteBatch", chatsHandler.DeleteChats())
 
-		r.Get("/ws", ws.WSHandler(h, log))
-
-		r.Post("/chats/{chatId}/messages", messagesHandler.SendMessage())
-		r.Patch("/chats/{chatId}/messages/read", messagesHandler.SetLastReadMessage())
-		r.Get("/chats/{chatId}/messages", messagesHandler.GetMessages())
-		r.Delete("/chats/{chatId}/messages/{messageId}", messagesHandler.DeleteMessage())
-		r.Post("/chats/{chatId}/messages/deleteBatch", messagesHandler.DeleteMessages())
+		r.Get("/ws", ws.WSHandler(h, chatsPolicy, eventLog, messagesHandler, log))
+
+		r.Get("/search/messages", messagesHandler.SearchMessages())
+		r.Post("/messages/forward", messagesHandler.ForwardMessages())
+
+		// Всё, что адресует конкретный чат, доступно только его участникам
+		r.Group(func(r chi.Router) {
+			r.Use(chatsPolicy.RequireMember)
+
+			r.Get("/chats/{chatId}", chatsHandler.GetChat())
+			r.Patch("/chats/{chatId}", chatsHandler.UpdateChat())
+			r.Delete("/chats/{chatId}", chatsHandler.DeleteChat())
+			r.Post("/chats/{chatId}/participants", chatsHandler.AddParticipants())
+			r.Patch("/chats/{chatId}/participants/{userId}", chatsHandler.SetParticipantRole())
+			r.Patch("/chats/{chatId}/settings", chatsHandler.UpdateChatSettings())
+			r.Delete("/chats/{chatId}/participants/{userId}", chatsHandler.RemoveParticipant())
+			r.Post("/chats/{chatId}/leave", chatsHandler.LeaveChat())
+
+			r.Post("/chats/{chatId}/messages", messagesHandler.SendMessage())
+			r.Patch("/chats/{chatId}/messages/read", messagesHandler.SetLastReadMessage())
+			r.Get("/chats/{chatId}/messages", messagesHandler.GetMessages())
+			r.Get("/chats/{chatId}/messages/search", messagesHandler.SearchChatMessages())
+			r.Patch("/chats/{chatId}/messages/{messageId}", messagesHandler.EditMessage())
+			r.Get("/chats/{chatId}/messages/{messageId}/edits", messagesHandler.GetMessageEdits())
+			r.Delete("/chats/{chatId}/messages/{messageId}", messagesHandler.DeleteMessage())
+			r.Post("/chats/{chatId}/messages/deleteBatch", messagesHandler.DeleteMessages())
+			r.Put("/chats/{chatId}/messages/{messageId}/reactions/{emoji}", messagesHandler.AddReaction())
+			r.Delete("/chats/{chatId}/messages/{messageId}/reactions/{emoji}", messagesHandler.RemoveReaction())
+			r.Get("/chats/{chatId}/pins", messagesHandler.GetPins())
+			r.Post("/chats/{chatId}/pins/{messageId}", messagesHandler.PinMessage())
+			r.Delete("/chats/{chatId}/pins/{messageId}", messagesHandler.UnpinMessage())
+		})
 
 		r.Post("/uploads/presign-upload", uploadsHandler.PresignUpload())
 		r.Post("/uploads/presign-download", uploadsHandler.PresignDownload())
@@ -181,6 +253,19 @@ func initDB(ctx context.Context, dsn string) (*sqlx.DB, error) {
 	return db, nil
 }
 
+func newWSBackend(cfg *appConfig.Config, db *sqlx.DB, log *slog.Logger) hub.Backend {
+	switch cfg.WS.Backend {
+	case appConfig.WSBackendPostgres:
+		return pgnotify.New(db, cfg.DatabaseDSN, log)
+	case appConfig.WSBackendMemory:
+		return hub.NewMemoryBackend()
+	default:
+		log.Error("unknown ws backend", slog.String("backend", cfg.WS.Backend))
+		os.Exit(1)
+		return nil
+	}
+}
+
 func setupPrettySlog() *slog.Logger {
 	opts := slogpretty.PrettyHandlerOptions{
 		SlogOpts: &slog.HandlerOptions{
@@ -0,0 +1,45 @@
+package chats
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// ChatsCursor — ключ сортировки последнего чата страницы. Список идёт так:
+// сначала закреплённые (от последнего закреплённого), потом по последней
+// активности, при равенстве — по убыванию id.
+type ChatsCursor struct {
+	// PinnedAt == nil — чат не закреплён
+	PinnedAt   *time.Time `json:"pa,omitempty"`
+	ActivityAt time.Time  `json:"at"`
+	ChatID     int64      `json:"id"`
+}
+
+func NewChatsCursor(c ChatListItem) ChatsCursor {
+	return ChatsCursor{
+		PinnedAt:   c.Settings.PinnedAt,
+		ActivityAt: c.LastActivityAt,
+		ChatID:     c.ID,
+	}
+}
+
+// Encode — непрозрачная для клиента строка для ?cursor=.
+func (c ChatsCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func ParseChatsCursor(s string) (*ChatsCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidChatsCursor
+	}
+
+	var c ChatsCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ChatID <= 0 {
+		return nil, ErrInvalidChatsCursor
+	}
+
+	return &c, nil
+}
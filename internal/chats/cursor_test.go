@@ -0,0 +1,36 @@
+package chats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChatsCursor_RoundTrip(t *testing.T) {
+	pinnedAt := time.Date(2025, 3, 1, 10, 0, 0, 123456000, time.UTC)
+
+	for _, c := range []ChatsCursor{
+		{ActivityAt: time.Date(2025, 3, 2, 8, 30, 0, 1000, time.UTC), ChatID: 7},
+		{PinnedAt: &pinnedAt, ActivityAt: time.Date(2025, 3, 2, 8, 30, 0, 0, time.UTC), ChatID: 42},
+	} {
+		got, err := ParseChatsCursor(c.Encode())
+		if err != nil {
+			t.Fatalf("ParseChatsCursor(%+v): %v", c, err)
+		}
+
+		if got.ChatID != c.ChatID || !got.ActivityAt.Equal(c.ActivityAt) {
+			t.Errorf("got %+v, want %+v", got, c)
+		}
+		if (got.PinnedAt == nil) != (c.PinnedAt == nil) ||
+			(got.PinnedAt != nil && !got.PinnedAt.Equal(*c.PinnedAt)) {
+			t.Errorf("pinned_at: got %v, want %v", got.PinnedAt, c.PinnedAt)
+		}
+	}
+}
+
+func TestParseChatsCursor_Invalid(t *testing.T) {
+	for _, s := range []string{"", "not base64!", "e30", "eyJpZCI6MH0"} {
+		if _, err := ParseChatsCursor(s); err != ErrInvalidChatsCursor {
+			t.Errorf("ParseChatsCursor(%q) = %v, want ErrInvalidChatsCursor", s, err)
+		}
+	}
+}
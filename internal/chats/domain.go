@@ -2,13 +2,75 @@ package chats
 
 import (
 	"context"
+	"time"
+	"unicode/utf8"
 
+	"github.com/kgellert/hodatay-messenger/internal/matters"
 	"github.com/kgellert/hodatay-messenger/internal/messages"
 	"github.com/kgellert/hodatay-messenger/internal/users"
 )
 
 type CreateChatRequest struct {
-	UserIDs []int64 `json:"user_ids" db:"user_ids"`
+	UserIDs  []int64 `json:"user_ids" db:"user_ids"`
+	MatterID *int64  `json:"matter_id"`
+	ChatMeta
+}
+
+// ChatType: direct — личный чат двоих, единственный на пару; состав и
+// оформление у него не меняются. group — всё остальное.
+type ChatType string
+
+const (
+	ChatTypeDirect ChatType = "direct"
+	ChatTypeGroup  ChatType = "group"
+)
+
+type CreateDirectChatRequest struct {
+	UserID int64 `json:"user_id"`
+}
+
+// ChatMeta — оформление группового чата. AvatarFileID — file_id
+// загруженной через uploads картинки.
+type ChatMeta struct {
+	Title        *string `json:"title" db:"title"`
+	Description  *string `json:"description" db:"description"`
+	AvatarFileID *string `json:"avatar_file_id" db:"avatar_file_id"`
+}
+
+const (
+	MaxTitleLen       = 128
+	MaxDescriptionLen = 1024
+)
+
+// Validate проверяет длины; пустые строки допустимы и означают "без значения".
+func (m ChatMeta) Validate() error {
+	if m.Title != nil && utf8.RuneCountInString(*m.Title) > MaxTitleLen {
+		return ErrTitleTooLong
+	}
+	if m.Description != nil && utf8.RuneCountInString(*m.Description) > MaxDescriptionLen {
+		return ErrDescriptionTooLong
+	}
+	return nil
+}
+
+// UpdateChatRequest: nil-поля не меняются, пустая строка очищает поле.
+// MatterID переносит чат в другое поручение, 0 — выводит из поручения.
+type UpdateChatRequest struct {
+	ChatMeta
+	MatterID *int64 `json:"matter_id"`
+}
+
+func (r UpdateChatRequest) Validate() error {
+	if r.MatterID != nil && *r.MatterID < 0 {
+		return matters.ErrInvalidMatterID
+	}
+	return r.ChatMeta.Validate()
+}
+
+// ChatUpdate — оформление и поручение чата после UpdateChat.
+type ChatUpdate struct {
+	ChatMeta
+	Matter *matters.Summary `json:"matter"`
 }
 
 type DeleteChatsRequest struct {
@@ -17,27 +79,104 @@ type DeleteChatsRequest struct {
 
 type ChatRow struct {
 	ChatID                     int64                       `db:"chat_id"`
-	UserID                     int64                       `db:"user_id"`
+	Type                       ChatType                    `db:"type"`
+	LastActivityAt             time.Time                   `db:"last_activity_at"`
 	LastMessage                messages.ChatLastMessageRow `db:"last_message"`
 	UnreadCount                int64                       `db:"unread_count"`
 	OthersMaxLastReadMessageID int64                       `db:"others_max_last_read_message_id"`
+	Matter                     matters.SummaryRow          `db:"matter"`
+	Settings                   ChatSettings                `db:"settings"`
+
+	ChatMeta
 }
 
 type ChatListItem struct {
 	ID                         int64             `json:"id" db:"chat_id"`
+	Type                       ChatType          `json:"type" db:"type"`
 	Users                      []users.User      `json:"users"`
+	LastActivityAt             time.Time         `json:"last_activity_at" db:"last_activity_at"`
 	LastMessage                *messages.Message `json:"last_message" db:"last_message"`
 	UnreadCount                int64             `json:"unread_count" db:"unread_count"`
 	OthersMaxLastReadMessageID int64             `json:"others_max_last_read_message_id" db:"others_max_last_read_message_id"`
+
+	// Matter — поручение, к которому относится чат, или nil
+	Matter   *matters.Summary `json:"matter" db:"-"`
+	Settings ChatSettings     `json:"settings" db:"-"`
+	ChatMeta
 }
 
 type ChatInfo struct {
 	ID    int64        `json:"id" db:"id"`
+	Type  ChatType     `json:"type" db:"type"`
 	Users []users.User `json:"users" db:"users"`
+	// Roles: user_id -> роль в чате
+	Roles map[int64]Role `json:"roles" db:"-"`
+
+	Matter *matters.Summary `json:"matter" db:"-"`
+	// LatestPin — последнее закреплённое сообщение, остальные — GET /chats/{chatId}/pins
+	LatestPin *messages.Pin `json:"latest_pin" db:"-"`
+	ChatMeta
+}
+
+// ChatSettings — личные настройки чата у конкретного участника.
+// MutedUntil == MutedForever — заглушён бессрочно.
+type ChatSettings struct {
+	MutedUntil *time.Time `json:"muted_until" db:"muted_until"`
+	PinnedAt   *time.Time `json:"pinned_at" db:"pinned_at"`
+	ArchivedAt *time.Time `json:"archived_at" db:"archived_at"`
+}
+
+var MutedForever = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// UpdateChatSettingsRequest: nil-поля не меняются. Muted без MutedUntil
+// глушит бессрочно, MutedUntil без Muted — до этого времени.
+type UpdateChatSettingsRequest struct {
+	Muted      *bool      `json:"muted"`
+	MutedUntil *time.Time `json:"muted_until"`
+	Pinned     *bool      `json:"pinned"`
+	Archived   *bool      `json:"archived"`
+}
+
+type ChatSettingsResponse struct {
+	Settings ChatSettings `json:"settings"`
+}
+
+// GetChatsParams: Archived == false — обычный список, true — архив,
+// nil — все чаты вместе. Query ищет по названию чата и именам собеседников.
+type GetChatsParams struct {
+	Limit      int
+	Cursor     *ChatsCursor
+	Archived   *bool
+	MatterID   *int64
+	Type       *ChatType
+	UnreadOnly bool
+	Query      string
+}
+
+// ChatsPage: NextCursor == nil — дальше чатов нет.
+type ChatsPage struct {
+	Chats      []ChatListItem
+	NextCursor *ChatsCursor
+}
+
+type AddParticipantsRequest struct {
+	UserIDs []int64 `json:"user_ids"`
+}
+
+// AddParticipantsResponse: только те, кого в чате ещё не было.
+type AddParticipantsResponse struct {
+	Users []users.User `json:"users"`
+}
+
+type SetParticipantRoleRequest struct {
+	Role Role `json:"role"`
 }
 
 type GetChatsResponse struct {
-	Chats []ChatListItem `json:"chats"`
+	Chats   []ChatListItem `json:"chats"`
+	HasMore bool           `json:"has_more"`
+	// NextCursor передаётся в ?cursor= за следующей страницей
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 type GetChatResponse struct {
@@ -53,10 +192,39 @@ type GetUnreadMessagesCountResponse struct {
 }
 
 type ChatsService interface {
-	CreateChat(ctx context.Context, userIDs []int64) (*ChatInfo, error)
+	// CreateChat: ownerID получает роль owner, остальные — member
+	CreateChat(ctx context.Context, ownerID int64, req CreateChatRequest) (*ChatInfo, error)
+	// GetOrCreateDirectChat: created=false — чат с peerID уже был
+	GetOrCreateDirectChat(ctx context.Context, userID, peerID int64) (chat *ChatInfo, created bool, err error)
+	// UpdateChat возвращает итоговые поля и признак того, что поменялось название
+	UpdateChat(ctx context.Context, chatID, actorID int64, req UpdateChatRequest) (chat *ChatUpdate, titleChanged bool, err error)
 	DeleteChat(ctx context.Context, chatID int64) error
 	DeleteChats(ctx context.Context, chatIDs []int64) ([]int64, error)
-	GetChats(ctx context.Context, userID int64) ([]ChatListItem, error)
-	GetChat(ctx context.Context, chatID int64) (*ChatInfo, error)
+	GetChats(ctx context.Context, userID int64, params GetChatsParams) (*ChatsPage, error)
+	// GetMatterChats — GetChats по params.MatterID, но с ErrMatterNotFound
+	// для несуществующего поручения
+	GetMatterChats(ctx context.Context, userID int64, params GetChatsParams) (*ChatsPage, error)
+	// GetChat: viewerID — для кого собирается ChatInfo (LatestPin без скрытых им сообщений)
+	GetChat(ctx context.Context, chatID, viewerID int64) (*ChatInfo, error)
 	GetUnreadMessagesCount(ctx context.Context, userID int64) (int, error)
+	SetParticipantRole(ctx context.Context, chatID, userID int64, role Role) error
+	UpdateChatSettings(ctx context.Context, chatID, userID int64, req UpdateChatSettingsRequest) (ChatSettings, error)
+	// AddChatParticipants возвращает только новых участников
+	AddChatParticipants(ctx context.Context, chatID int64, userIDs []int64) ([]users.User, error)
+	RemoveChatParticipant(ctx context.Context, chatID, userID int64) error
+	// LeaveChat: если вышел владелец, владение переходит к самому давнему
+	// админу, а без админов — к самому давнему участнику; его id и возвращается
+	LeaveChat(ctx context.Context, chatID, userID int64) (newOwnerID int64, err error)
+}
+
+type PartnersRepo interface {
+	// GetChatPartnerIDs: все, с кем userID состоит хотя бы в одном общем чате.
+	GetChatPartnerIDs(ctx context.Context, userID int64) ([]int64, error)
+}
+
+type MembershipRepo interface {
+	IsChatParticipant(ctx context.Context, chatID, userID int64) (bool, error)
+	FilterParticipantChats(ctx context.Context, userID int64, chatIDs []int64) ([]int64, error)
+	// GetParticipantRoles: chat_id -> роль userID; чатов, где он не участник, в ответе нет
+	GetParticipantRoles(ctx context.Context, userID int64, chatIDs []int64) (map[int64]Role, error)
 }
@@ -5,8 +5,22 @@ import (
 )
 
 var (
-	ErrEmptyParticipants = errors.New("no participants provided")
-	ErrChatsNotFound     = errors.New("chats not found")
-	ErrChatNotFound      = errors.New("chat not found")
-	ErrChatIsNil         = errors.New("chat is nil")
+	ErrEmptyParticipants  = errors.New("no participants provided")
+	ErrChatsNotFound      = errors.New("chats not found")
+	ErrChatNotFound       = errors.New("chat not found")
+	ErrChatIsNil          = errors.New("chat is nil")
+	ErrInvalidChatID      = errors.New("invalid chat_id")
+	ErrNotChatMember      = errors.New("user is not a member of the chat")
+	ErrPermissionDenied   = errors.New("chat role does not allow this action")
+	ErrInvalidRole        = errors.New("role must be admin or member")
+	ErrOwnerRoleChange    = errors.New("chat owner role cannot be changed")
+	ErrOwnerRemoval       = errors.New("chat owner cannot be removed")
+	ErrTitleTooLong       = errors.New("chat title is too long")
+	ErrDescriptionTooLong = errors.New("chat description is too long")
+	ErrInvalidAvatar      = errors.New("avatar must be a confirmed image upload")
+	ErrDirectChat         = errors.New("direct chat participants and info cannot be changed")
+	ErrInvalidDirectPeer  = errors.New("direct chat requires another existing user")
+	ErrInvalidChatsFilter = errors.New("invalid chat list filter")
+	ErrInvalidChatsCursor = errors.New("invalid chat list cursor")
+	ErrInvalidMutedUntil  = errors.New("muted_until must be in the future")
 )
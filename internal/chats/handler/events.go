@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
+	"github.com/kgellert/hodatay-messenger/internal/messages"
+	"github.com/kgellert/hodatay-messenger/internal/ws"
+)
+
+// recordMembership пишет в ленту системное сообщение и рассылает его
+// вместе с событием о составе чата. Состав к этому моменту уже изменён,
+// поэтому ошибки только логируются.
+func (h *Handler) recordMembership(
+	ctx context.Context,
+	log *slog.Logger,
+	chatID, actorID int64,
+	system messages.SystemPayload,
+	typ ws.EventType,
+	data any,
+) {
+	h.systemMessage(ctx, log, chatID, actorID, system)
+	h.publish(ctx, log, chatID, typ, data)
+}
+
+// systemMessage пишет системное сообщение в ленту и рассылает его как message.new.
+func (h *Handler) systemMessage(
+	ctx context.Context,
+	log *slog.Logger,
+	chatID, actorID int64,
+	system messages.SystemPayload,
+) {
+	ctx = context.WithoutCancel(ctx)
+
+	msg, err := h.systemMessages.CreateSystemMessage(ctx, chatID, actorID, system)
+	if err != nil {
+		log.Error("failed to create system message", sl.Err(err))
+		return
+	}
+
+	h.publish(ctx, log, chatID, ws.MessageNew, ws.MessageNewPayload{Message: *msg})
+}
+
+// publish пишет событие в журнал чата. Вызывается после того, как изменение
+// сохранено, поэтому не зависит от отмены запроса.
+func (h *Handler) publish(ctx context.Context, log *slog.Logger, chatID int64, typ ws.EventType, data any) {
+	ctx = context.WithoutCancel(ctx)
+
+	evt, err := ws.NewEvent(chatID, typ, data)
+	if err != nil {
+		log.Error("failed to build ws event", sl.Err(err))
+		return
+	}
+
+	if err := h.events.Publish(ctx, evt); err != nil {
+		log.Error("failed to publish ws event", sl.Err(err))
+	}
+}
+
+func (h *Handler) notifyUsers(log *slog.Logger, userIDs []int64, chatID int64, typ ws.EventType, data any) {
+	evt, err := ws.NewEvent(chatID, typ, data)
+	if err != nil {
+		log.Error("failed to build ws event", sl.Err(err))
+		return
+	}
+
+	b, err := json.Marshal(evt)
+	if err != nil {
+		log.Error("failed to marshal ws event", sl.Err(err))
+		return
+	}
+
+	h.rooms.SendToUsers(userIDs, b)
+}
@@ -4,26 +4,54 @@ import (
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
 	"github.com/kgellert/hodatay-messenger/internal/chats"
+	"github.com/kgellert/hodatay-messenger/internal/chats/policy"
 	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
+	"github.com/kgellert/hodatay-messenger/internal/matters"
+	mattershandler "github.com/kgellert/hodatay-messenger/internal/matters/handler"
+	"github.com/kgellert/hodatay-messenger/internal/messages"
 	"github.com/kgellert/hodatay-messenger/internal/transport/httpapi"
+	"github.com/kgellert/hodatay-messenger/internal/users"
 	userhandlers "github.com/kgellert/hodatay-messenger/internal/users/handlers"
+	"github.com/kgellert/hodatay-messenger/internal/ws"
 )
 
+// Rooms — то, что нужно от хаба при смене состава чата.
+type Rooms interface {
+	SendToUsers(userIDs []int64, payload []byte)
+	UnsubscribeUsers(chatID int64, userIDs []int64)
+}
+
 type Handler struct {
-	service chats.ChatsService
-	log     *slog.Logger
+	service        chats.ChatsService
+	policy         *policy.Policy
+	systemMessages messages.SystemMessageWriter
+	events         ws.Publisher
+	rooms          Rooms
+	log            *slog.Logger
 }
 
 func New(
 	service chats.ChatsService,
+	policy *policy.Policy,
+	systemMessages messages.SystemMessageWriter,
+	events ws.Publisher,
+	rooms Rooms,
 	log *slog.Logger,
 ) *Handler {
-	return &Handler{service: service, log: log}
+	return &Handler{
+		service:        service,
+		policy:         policy,
+		systemMessages: systemMessages,
+		events:         events,
+		rooms:          rooms,
+		log:            log,
+	}
 }
 
 func (h *Handler) GetChats() http.HandlerFunc {
@@ -35,9 +63,15 @@ func (h *Handler) GetChats() http.HandlerFunc {
 			slog.String("request_id", middleware.GetReqID(r.Context())),
 		)
 
-		uid := userhandlers.UserID(r)
+		// по умолчанию — обычный список без архива
+		archived := false
+		params, err := parseChatsParams(r, &archived)
+		if err != nil {
+			httpapi.WriteError(w, r, err)
+			return
+		}
 
-		chatList, err := h.service.GetChats(r.Context(), uid)
+		page, err := h.service.GetChats(r.Context(), userhandlers.UserID(r), params)
 
 		if err != nil {
 			log.Error("Failed to get chats", sl.Err(err))
@@ -45,12 +79,118 @@ func (h *Handler) GetChats() http.HandlerFunc {
 			return
 		}
 
-		log.Info("Chats fetched", slog.Any("chats", chatList))
+		log.Info("Chats fetched", slog.Int("count", len(page.Chats)))
 
-		render.JSON(w, r, chats.GetChatsResponse{
-			Chats: chatList,
-		})
+		render.JSON(w, r, newGetChatsResponse(page))
+	}
+}
+
+// GetMatterChats — чаты поручения {matterId}, в которых состоит пользователь.
+func (h *Handler) GetMatterChats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.chats.get.matter_chats"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		matterID, ok := mattershandler.MatterID(r)
+		if !ok {
+			httpapi.WriteError(w, r, matters.ErrInvalidMatterID)
+			return
+		}
+
+		// в поручении показываем и архивные чаты, если не попросили иначе
+		params, err := parseChatsParams(r, nil)
+		if err != nil {
+			httpapi.WriteError(w, r, err)
+			return
+		}
+		params.MatterID = &matterID
+
+		page, err := h.service.GetMatterChats(r.Context(), userhandlers.UserID(r), params)
+		if err != nil {
+			log.Error("failed to get matter chats", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, newGetChatsResponse(page))
+	}
+}
+
+// parseChatsParams разбирает ?limit, ?cursor, ?archived, ?type, ?unread, ?q
+// и ?matter_id. archived — значение по умолчанию, если ?archived не передан.
+func parseChatsParams(r *http.Request, archived *bool) (chats.GetChatsParams, error) {
+	const defaultLimit = 30
+	const maxLimit = 100
+
+	q := r.URL.Query()
+	params := chats.GetChatsParams{
+		Limit:    defaultLimit,
+		Archived: archived,
+		Query:    strings.TrimSpace(q.Get("q")),
+	}
+
+	if raw := q.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			return params, messages.ErrInvalidLimit
+		}
+		params.Limit = min(parsed, maxLimit)
+	}
+
+	if raw := q.Get("cursor"); raw != "" {
+		cursor, err := chats.ParseChatsCursor(raw)
+		if err != nil {
+			return params, err
+		}
+		params.Cursor = cursor
+	}
+
+	if raw := q.Get("archived"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return params, chats.ErrInvalidChatsFilter
+		}
+		params.Archived = &v
 	}
+
+	if raw := q.Get("unread"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return params, chats.ErrInvalidChatsFilter
+		}
+		params.UnreadOnly = v
+	}
+
+	if raw := q.Get("type"); raw != "" {
+		t := chats.ChatType(raw)
+		if t != chats.ChatTypeDirect && t != chats.ChatTypeGroup {
+			return params, chats.ErrInvalidChatsFilter
+		}
+		params.Type = &t
+	}
+
+	if raw := q.Get("matter_id"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id <= 0 {
+			return params, matters.ErrInvalidMatterID
+		}
+		params.MatterID = &id
+	}
+
+	return params, nil
+}
+
+func newGetChatsResponse(page *chats.ChatsPage) chats.GetChatsResponse {
+	resp := chats.GetChatsResponse{Chats: page.Chats}
+	if page.NextCursor != nil {
+		resp.HasMore = true
+		resp.NextCursor = page.NextCursor.Encode()
+	}
+	return resp
 }
 
 func (h *Handler) CreateChat() http.HandlerFunc {
@@ -69,7 +209,13 @@ func (h *Handler) CreateChat() http.HandlerFunc {
 			return
 		}
 
-		chatInfo, err := h.service.CreateChat(r.Context(), req.UserIDs)
+		if err := req.ChatMeta.Validate(); err != nil {
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		// Создатель всегда участник своего чата, причём владелец
+		chatInfo, err := h.service.CreateChat(r.Context(), userhandlers.UserID(r), req)
 		if err != nil {
 			log.Error("failed to create chat", sl.Err(err))
 			httpapi.WriteError(w, r, err)
@@ -82,6 +228,21 @@ func (h *Handler) CreateChat() http.HandlerFunc {
 			return
 		}
 
+		members := make([]int64, 0, len(chatInfo.Users))
+		for _, u := range chatInfo.Users {
+			if u.ID != userhandlers.UserID(r) {
+				members = append(members, u.ID)
+			}
+		}
+		system := messages.SystemPayload{
+			Event:   messages.SystemChatCreated,
+			UserIDs: members,
+		}
+		if chatInfo.Title != nil {
+			system.Title = *chatInfo.Title
+		}
+		h.systemMessage(r.Context(), log, chatInfo.ID, userhandlers.UserID(r), system)
+
 		log.Info("Chat created", slog.Any("chat", chatInfo))
 
 		render.Status(r, http.StatusCreated)
@@ -91,6 +252,115 @@ func (h *Handler) CreateChat() http.HandlerFunc {
 	}
 }
 
+// GetOrCreateDirectChat отдаёт личный чат с user_id: 201 — если он только
+// что создан, 200 — если уже был.
+func (h *Handler) GetOrCreateDirectChat() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.chats.direct"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req chats.CreateDirectChatRequest
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			log.Error("failed to decode request", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		chatInfo, created, err := h.service.GetOrCreateDirectChat(r.Context(), userhandlers.UserID(r), req.UserID)
+		if err != nil {
+			log.Error("failed to get or create direct chat", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		if created {
+			// Собеседник ещё не подписан на новый чат
+			h.notifyUsers(log, []int64{req.UserID}, chatInfo.ID, ws.ChatMemberAdded, ws.ChatMemberAddedPayload{
+				ActorID: userhandlers.UserID(r),
+				Users:   chatInfo.Users,
+			})
+			render.Status(r, http.StatusCreated)
+		}
+
+		render.JSON(w, r, chats.GetChatResponse{
+			Chat: *chatInfo,
+		})
+	}
+}
+
+// UpdateChat меняет название, описание, аватар и поручение чата.
+func (h *Handler) UpdateChat() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.chats.update.chat"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		chatID, err := strconv.ParseInt(chi.URLParam(r, "chatId"), 10, 64)
+		if err != nil || chatID <= 0 {
+			httpapi.WriteError(w, r, chats.ErrInvalidChatID)
+			return
+		}
+
+		var req chats.UpdateChatRequest
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			log.Error("failed to decode request", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		if err := req.Validate(); err != nil {
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		actorID := userhandlers.UserID(r)
+
+		if err := h.policy.Check(r.Context(), chatID, actorID, chats.PermEditInfo); err != nil {
+			log.Warn("update chat denied", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		updated, titleChanged, err := h.service.UpdateChat(r.Context(), chatID, actorID, req)
+		if err != nil {
+			log.Error("failed to update chat", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		if titleChanged {
+			system := messages.SystemPayload{Event: messages.SystemTitleChanged}
+			if updated.Title != nil {
+				system.Title = *updated.Title
+			}
+			h.systemMessage(r.Context(), log, chatID, actorID, system)
+		}
+
+		h.publish(r.Context(), log, chatID, ws.ChatUpdated, ws.ChatUpdatedPayload{
+			ActorID:    actorID,
+			ChatUpdate: *updated,
+		})
+
+		chatInfo, err := h.service.GetChat(r.Context(), chatID, actorID)
+		if err != nil {
+			log.Error("failed to get chat", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, chats.GetChatResponse{
+			Chat: *chatInfo,
+		})
+	}
+}
+
 func (h *Handler) DeleteChat() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.chats.delete.chat"
@@ -108,6 +378,12 @@ func (h *Handler) DeleteChat() http.HandlerFunc {
 			return
 		}
 
+		if err := h.policy.Check(r.Context(), chatID, userhandlers.UserID(r), chats.PermDeleteChat); err != nil {
+			log.Warn("delete chat denied", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
 		err = h.service.DeleteChat(r.Context(), chatID)
 		if err != nil {
 			log.Error("failed to delete chat", sl.Err(err))
@@ -135,6 +411,12 @@ func (h *Handler) DeleteChats() http.HandlerFunc {
 			return
 		}
 
+		if err := h.policy.CheckAll(r.Context(), userhandlers.UserID(r), req.ChatIDs, chats.PermDeleteChat); err != nil {
+			log.Warn("delete chats denied", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
 		chatIDs, err := h.service.DeleteChats(r.Context(), req.ChatIDs)
 		if err != nil {
 			log.Error("failed to delete chats", sl.Err(err))
@@ -165,7 +447,7 @@ func (h *Handler) GetChat() http.HandlerFunc {
 			return
 		}
 
-		chatInfo, err := h.service.GetChat(r.Context(), chatID)
+		chatInfo, err := h.service.GetChat(r.Context(), chatID, userhandlers.UserID(r))
 		if err != nil {
 			log.Error("failed to get chat", sl.Err(err))
 			httpapi.WriteError(w, r, err)
@@ -208,3 +490,47 @@ func (h *Handler) GetUnreadMessagesCount() http.HandlerFunc {
 		})
 	}
 }
+
+func (h *Handler) SetParticipantRole() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.chats.set.participant_role"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		chatID, err := strconv.ParseInt(chi.URLParam(r, "chatId"), 10, 64)
+		if err != nil || chatID <= 0 {
+			httpapi.WriteError(w, r, chats.ErrInvalidChatID)
+			return
+		}
+
+		userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+		if err != nil || userID <= 0 {
+			httpapi.WriteError(w, r, users.ErrInvalidUserID)
+			return
+		}
+
+		var req chats.SetParticipantRoleRequest
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			log.Error("failed to decode request", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		if err := h.policy.Check(r.Context(), chatID, userhandlers.UserID(r), chats.PermManageRoles); err != nil {
+			log.Warn("set participant role denied", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		if err := h.service.SetParticipantRole(r.Context(), chatID, userID, req.Role); err != nil {
+			log.Error("failed to set participant role", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
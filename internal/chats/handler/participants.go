@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/kgellert/hodatay-messenger/internal/chats"
+	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
+	"github.com/kgellert/hodatay-messenger/internal/messages"
+	"github.com/kgellert/hodatay-messenger/internal/transport/httpapi"
+	"github.com/kgellert/hodatay-messenger/internal/users"
+	userhandlers "github.com/kgellert/hodatay-messenger/internal/users/handlers"
+	"github.com/kgellert/hodatay-messenger/internal/ws"
+)
+
+func (h *Handler) AddParticipants() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.chats.add.participants"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		chatID, err := strconv.ParseInt(chi.URLParam(r, "chatId"), 10, 64)
+		if err != nil || chatID <= 0 {
+			httpapi.WriteError(w, r, chats.ErrInvalidChatID)
+			return
+		}
+
+		var req chats.AddParticipantsRequest
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			log.Error("failed to decode request", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		actorID := userhandlers.UserID(r)
+
+		if err := h.policy.Check(r.Context(), chatID, actorID, chats.PermAddParticipants); err != nil {
+			log.Warn("add participants denied", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		added, err := h.service.AddChatParticipants(r.Context(), chatID, req.UserIDs)
+		if err != nil {
+			log.Error("failed to add participants", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		if len(added) > 0 {
+			userIDs := make([]int64, 0, len(added))
+			for _, u := range added {
+				userIDs = append(userIDs, u.ID)
+			}
+
+			payload := ws.ChatMemberAddedPayload{ActorID: actorID, Users: added}
+			h.recordMembership(r.Context(), log, chatID, actorID, messages.SystemPayload{
+				Event:   messages.SystemMembersAdded,
+				UserIDs: userIDs,
+			}, ws.ChatMemberAdded, payload)
+
+			// Новые участники ещё не подписаны на чат — сообщаем им напрямую,
+			// чтобы клиент подписался
+			h.notifyUsers(log, userIDs, chatID, ws.ChatMemberAdded, payload)
+		}
+
+		render.JSON(w, r, chats.AddParticipantsResponse{
+			Users: added,
+		})
+	}
+}
+
+// RemoveParticipant исключает участника; удаление самого себя — это выход из чата.
+func (h *Handler) RemoveParticipant() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.chats.remove.participant"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		chatID, err := strconv.ParseInt(chi.URLParam(r, "chatId"), 10, 64)
+		if err != nil || chatID <= 0 {
+			httpapi.WriteError(w, r, chats.ErrInvalidChatID)
+			return
+		}
+
+		userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+		if err != nil || userID <= 0 {
+			httpapi.WriteError(w, r, users.ErrInvalidUserID)
+			return
+		}
+
+		actorID := userhandlers.UserID(r)
+
+		if userID == actorID {
+			h.leave(w, r, log, chatID, actorID)
+			return
+		}
+
+		if err := h.policy.Check(r.Context(), chatID, actorID, chats.PermRemoveParticipants); err != nil {
+			log.Warn("remove participant denied", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		if err := h.service.RemoveChatParticipant(r.Context(), chatID, userID); err != nil {
+			log.Error("failed to remove participant", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		h.recordMembership(r.Context(), log, chatID, actorID, messages.SystemPayload{
+			Event:   messages.SystemMemberRemoved,
+			UserIDs: []int64{userID},
+		}, ws.ChatMemberRemoved, ws.ChatMemberRemovedPayload{ActorID: actorID, UserID: userID})
+
+		h.rooms.UnsubscribeUsers(chatID, []int64{userID})
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *Handler) LeaveChat() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.chats.leave"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		chatID, err := strconv.ParseInt(chi.URLParam(r, "chatId"), 10, 64)
+		if err != nil || chatID <= 0 {
+			httpapi.WriteError(w, r, chats.ErrInvalidChatID)
+			return
+		}
+
+		h.leave(w, r, log, chatID, userhandlers.UserID(r))
+	}
+}
+
+func (h *Handler) leave(w http.ResponseWriter, r *http.Request, log *slog.Logger, chatID, userID int64) {
+	newOwnerID, err := h.service.LeaveChat(r.Context(), chatID, userID)
+	if err != nil {
+		log.Error("failed to leave chat", sl.Err(err))
+		httpapi.WriteError(w, r, err)
+		return
+	}
+
+	h.recordMembership(r.Context(), log, chatID, userID, messages.SystemPayload{
+		Event:   messages.SystemMemberLeft,
+		UserIDs: []int64{userID},
+	}, ws.ChatMemberRemoved, ws.ChatMemberRemovedPayload{
+		ActorID:    userID,
+		UserID:     userID,
+		NewOwnerID: newOwnerID,
+	})
+
+	h.rooms.UnsubscribeUsers(chatID, []int64{userID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/kgellert/hodatay-messenger/internal/chats"
+	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
+	"github.com/kgellert/hodatay-messenger/internal/transport/httpapi"
+	userhandlers "github.com/kgellert/hodatay-messenger/internal/users/handlers"
+	"github.com/kgellert/hodatay-messenger/internal/ws"
+)
+
+// UpdateChatSettings меняет личные настройки чата (mute, pin, archive) и
+// рассылает их остальным устройствам пользователя.
+func (h *Handler) UpdateChatSettings() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.chats.update.settings"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		chatID, err := strconv.ParseInt(chi.URLParam(r, "chatId"), 10, 64)
+		if err != nil || chatID <= 0 {
+			httpapi.WriteError(w, r, chats.ErrInvalidChatID)
+			return
+		}
+
+		var req chats.UpdateChatSettingsRequest
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			log.Error("failed to decode request", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		if req.MutedUntil != nil && !req.MutedUntil.After(time.Now()) {
+			httpapi.WriteError(w, r, chats.ErrInvalidMutedUntil)
+			return
+		}
+
+		userID := userhandlers.UserID(r)
+
+		settings, err := h.service.UpdateChatSettings(r.Context(), chatID, userID, req)
+		if err != nil {
+			log.Error("failed to update chat settings", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		h.notifyUsers(log, []int64{userID}, chatID, ws.ChatSettingsUpdated, ws.ChatSettingsUpdatedPayload{
+			Settings: settings,
+		})
+
+		render.JSON(w, r, chats.ChatSettingsResponse{
+			Settings: settings,
+		})
+	}
+}
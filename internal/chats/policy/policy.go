@@ -0,0 +1,116 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kgellert/hodatay-messenger/internal/chats"
+	"github.com/kgellert/hodatay-messenger/internal/transport/httpapi"
+	userhandlers "github.com/kgellert/hodatay-messenger/internal/users/handlers"
+)
+
+// Policy решает, может ли пользователь работать с чатом: читать и писать
+// может любой участник, остальное — по роли (chats.Role.Can).
+type Policy struct {
+	repo chats.MembershipRepo
+}
+
+func New(repo chats.MembershipRepo) *Policy {
+	return &Policy{repo: repo}
+}
+
+func (p *Policy) CheckMember(ctx context.Context, chatID, userID int64) error {
+	ok, err := p.repo.IsChatParticipant(ctx, chatID, userID)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return chats.ErrNotChatMember
+	}
+
+	return nil
+}
+
+// CheckMemberAll возвращает ErrNotChatMember, если хотя бы в одном из чатов
+// пользователь не участвует.
+func (p *Policy) CheckMemberAll(ctx context.Context, userID int64, chatIDs []int64) error {
+	allowed, err := p.repo.FilterParticipantChats(ctx, userID, chatIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, chatID := range chatIDs {
+		if !slices.Contains(allowed, chatID) {
+			return chats.ErrNotChatMember
+		}
+	}
+
+	return nil
+}
+
+// Check возвращает ErrNotChatMember, если пользователь не в чате, и
+// ErrPermissionDenied, если его роль не даёт права perm.
+func (p *Policy) Check(ctx context.Context, chatID, userID int64, perm chats.Permission) error {
+	return p.CheckAll(ctx, userID, []int64{chatID}, perm)
+}
+
+// CheckAll — Check сразу для нескольких чатов.
+func (p *Policy) CheckAll(ctx context.Context, userID int64, chatIDs []int64, perm chats.Permission) error {
+	roles, err := p.repo.GetParticipantRoles(ctx, userID, chatIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, chatID := range chatIDs {
+		role, ok := roles[chatID]
+		if !ok {
+			return chats.ErrNotChatMember
+		}
+		if !role.Can(perm) {
+			return chats.ErrPermissionDenied
+		}
+	}
+
+	return nil
+}
+
+// Can — как Check, но отказ по роли не ошибка, а false.
+func (p *Policy) Can(ctx context.Context, chatID, userID int64, perm chats.Permission) (bool, error) {
+	err := p.Check(ctx, chatID, userID, perm)
+	if errors.Is(err, chats.ErrPermissionDenied) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// MemberChats оставляет из chatIDs только чаты, где пользователь участник.
+func (p *Policy) MemberChats(ctx context.Context, userID int64, chatIDs []int64) ([]int64, error) {
+	return p.repo.FilterParticipantChats(ctx, userID, chatIDs)
+}
+
+// RequireMember — middleware для маршрутов с {chatId}.
+// Должен стоять после WithUser.
+func (p *Policy) RequireMember(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chatID, err := strconv.ParseInt(chi.URLParam(r, "chatId"), 10, 64)
+		if err != nil || chatID <= 0 {
+			httpapi.WriteError(w, r, chats.ErrInvalidChatID)
+			return
+		}
+
+		if err := p.CheckMember(r.Context(), chatID, userhandlers.UserID(r)); err != nil {
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
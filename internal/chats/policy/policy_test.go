@@ -0,0 +1,94 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/kgellert/hodatay-messenger/internal/chats"
+)
+
+type fakeMembership struct {
+	chatIDs []int64
+	roles   map[int64]chats.Role
+}
+
+func (f fakeMembership) IsChatParticipant(_ context.Context, chatID, _ int64) (bool, error) {
+	return slices.Contains(f.chatIDs, chatID), nil
+}
+
+func (f fakeMembership) FilterParticipantChats(_ context.Context, _ int64, chatIDs []int64) ([]int64, error) {
+	result := []int64{}
+	for _, id := range chatIDs {
+		if slices.Contains(f.chatIDs, id) {
+			result = append(result, id)
+		}
+	}
+	return result, nil
+}
+
+func (f fakeMembership) GetParticipantRoles(_ context.Context, _ int64, chatIDs []int64) (map[int64]chats.Role, error) {
+	result := map[int64]chats.Role{}
+	for _, id := range chatIDs {
+		if role, ok := f.roles[id]; ok {
+			result[id] = role
+		}
+	}
+	return result, nil
+}
+
+func TestPolicy_CheckMemberAll(t *testing.T) {
+	p := New(fakeMembership{chatIDs: []int64{1, 2}})
+
+	tests := []struct {
+		name    string
+		chatIDs []int64
+		wantErr error
+	}{
+		{name: "all member chats", chatIDs: []int64{1, 2}},
+		{name: "one foreign chat", chatIDs: []int64{1, 3}, wantErr: chats.ErrNotChatMember},
+		{name: "empty", chatIDs: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.CheckMemberAll(context.Background(), 1, tt.chatIDs)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckMemberAll() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPolicy_Check(t *testing.T) {
+	p := New(fakeMembership{roles: map[int64]chats.Role{
+		1: chats.RoleOwner,
+		2: chats.RoleAdmin,
+		3: chats.RoleMember,
+		5: chats.RolePeer,
+	}})
+
+	tests := []struct {
+		name    string
+		chatID  int64
+		perm    chats.Permission
+		wantErr error
+	}{
+		{name: "owner deletes chat", chatID: 1, perm: chats.PermDeleteChat},
+		{name: "admin deletes chat", chatID: 2, perm: chats.PermDeleteChat, wantErr: chats.ErrPermissionDenied},
+		{name: "admin deletes others messages", chatID: 2, perm: chats.PermDeleteOthersMessages},
+		{name: "member pins", chatID: 3, perm: chats.PermPinMessages, wantErr: chats.ErrPermissionDenied},
+		{name: "peer deletes direct chat", chatID: 5, perm: chats.PermDeleteChat},
+		{name: "peer deletes others messages", chatID: 5, perm: chats.PermDeleteOthersMessages, wantErr: chats.ErrPermissionDenied},
+		{name: "peer pins", chatID: 5, perm: chats.PermPinMessages},
+		{name: "not a member", chatID: 4, perm: chats.PermPinMessages, wantErr: chats.ErrNotChatMember},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Check(context.Background(), tt.chatID, 1, tt.perm)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Check() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,50 +2,98 @@ package repo
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"slices"
+	"strings"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/kgellert/hodatay-messenger/internal/chats"
+	"github.com/kgellert/hodatay-messenger/internal/matters"
 	messagesdomain "github.com/kgellert/hodatay-messenger/internal/messages"
 	uploadsdomain "github.com/kgellert/hodatay-messenger/internal/uploads/domain"
 	"github.com/kgellert/hodatay-messenger/internal/users"
 	"github.com/lib/pq"
 )
 
+// Messages — то, что репозиторию чатов нужно от сообщений: реакции
+// последнего сообщения в списке и закреп для ChatInfo.
+type Messages interface {
+	messagesdomain.ReactionsReader
+	messagesdomain.PinsReader
+}
+
 type Repo struct {
 	db        *sqlx.DB
 	usersRepo users.Repo
+	messages  Messages
 }
 
-func New(db *sqlx.DB, usersRepo users.Repo) *Repo {
-	return &Repo{db: db, usersRepo: usersRepo}
+func New(db *sqlx.DB, usersRepo users.Repo, messages Messages) *Repo {
+	return &Repo{db: db, usersRepo: usersRepo, messages: messages}
 }
 
-func (s *Repo) CreateChat(ctx context.Context, userIDs []int64) (*chats.ChatInfo, error) {
+func (s *Repo) CreateChat(ctx context.Context, ownerID int64, req chats.CreateChatRequest) (*chats.ChatInfo, error) {
 	const op = "storage.postgres.CreateChat"
 
+	userIDs, meta := req.UserIDs, req.ChatMeta
+
 	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return nil, fmt.Errorf("%s: begin tx: %w", op, err)
 	}
 	defer tx.Rollback()
 
-	var chatID int64
-	err = tx.QueryRowxContext(
+	if err := checkAvatar(ctx, tx, ownerID, meta.AvatarFileID); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var matter *matters.Summary
+	if req.MatterID != nil {
+		matter, err = getOpenMatter(ctx, tx, *req.MatterID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	var created struct {
+		ID   int64          `db:"id"`
+		Type chats.ChatType `db:"type"`
+		chats.ChatMeta
+	}
+	err = tx.GetContext(
 		ctx,
-		`INSERT INTO chats (matter_id) VALUES ($1)
-		RETURNING id`,
-		1,
-	).Scan(&chatID)
+		&created,
+		`INSERT INTO chats (matter_id, title, description, avatar_file_id)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''))
+		RETURNING id, type, `+chatMetaColumns,
+		req.MatterID, meta.Title, meta.Description, meta.AvatarFileID,
+	)
 
 	if err != nil {
 		return nil, fmt.Errorf("%s: insert chat: %w", op, err)
 	}
+	chatID := created.ID
 
-	users, err := s.addChatParticipants(ctx, tx, chatID, userIDs)
+	owner, err := s.addChatParticipants(ctx, tx, chatID, []int64{ownerID}, chats.RoleOwner)
 	if err != nil {
-		return nil, fmt.Errorf("%s: add participants: %w", op, err)
+		return nil, fmt.Errorf("%s: add owner: %w", op, err)
+	}
+
+	members := slices.DeleteFunc(slices.Clone(userIDs), func(id int64) bool { return id == ownerID })
+	users := owner
+	roles := map[int64]chats.Role{ownerID: chats.RoleOwner}
+
+	if len(members) > 0 {
+		added, err := s.addChatParticipants(ctx, tx, chatID, members, chats.RoleMember)
+		if err != nil {
+			return nil, fmt.Errorf("%s: add participants: %w", op, err)
+		}
+		for _, u := range added {
+			roles[u.ID] = chats.RoleMember
+		}
+		users = append(users, added...)
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -53,15 +101,230 @@ func (s *Repo) CreateChat(ctx context.Context, userIDs []int64) (*chats.ChatInfo
 	}
 
 	chatInfo := &chats.ChatInfo{
-		ID:    chatID,
-		Users: users,
+		ID:       chatID,
+		Type:     created.Type,
+		Users:    users,
+		Roles:    roles,
+		Matter:   matter,
+		ChatMeta: created.ChatMeta,
 	}
 
 	return chatInfo, nil
 }
 
+const chatMetaColumns = `title, description, avatar_file_id`
+
+// UpdateChat меняет оформление и поручение чата: nil-поля не трогаются,
+// пустая строка очищает поле, MatterID = 0 выводит чат из поручения.
+func (s *Repo) UpdateChat(ctx context.Context, chatID, actorID int64, req chats.UpdateChatRequest) (*chats.ChatUpdate, bool, error) {
+	const op = "storage.postgres.UpdateChat"
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: begin: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := ensureGroupChat(ctx, tx, chatID); err != nil {
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var old chats.ChatMeta
+	err = tx.GetContext(ctx, &old, `
+		SELECT `+chatMetaColumns+` FROM chats WHERE id = $1 FOR UPDATE
+	`, chatID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, chats.ErrChatNotFound
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: select: %w", op, err)
+	}
+
+	if err := checkAvatar(ctx, tx, actorID, req.AvatarFileID); err != nil {
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if req.MatterID != nil && *req.MatterID != 0 {
+		if _, err := getOpenMatter(ctx, tx, *req.MatterID); err != nil {
+			return nil, false, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	var updated struct {
+		chats.ChatMeta
+		MatterID sql.NullInt64 `db:"matter_id"`
+	}
+	if err := tx.GetContext(ctx, &updated, `
+		UPDATE chats
+		SET title          = NULLIF(COALESCE($2, title), ''),
+		    description    = NULLIF(COALESCE($3, description), ''),
+		    avatar_file_id = NULLIF(COALESCE($4, avatar_file_id), ''),
+		    matter_id      = CASE WHEN $5::bigint IS NULL THEN matter_id ELSE NULLIF($5, 0) END
+		WHERE id = $1
+		RETURNING `+chatMetaColumns+`, matter_id`,
+		chatID, req.Title, req.Description, req.AvatarFileID, req.MatterID,
+	); err != nil {
+		return nil, false, fmt.Errorf("%s: update: %w", op, err)
+	}
+
+	result := &chats.ChatUpdate{ChatMeta: updated.ChatMeta}
+	if updated.MatterID.Valid {
+		result.Matter, err = getMatter(ctx, tx, updated.MatterID.Int64)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("%s: commit: %w", op, err)
+	}
+
+	return result, !sameString(old.Title, updated.Title), nil
+}
+
+// getMatter возвращает поручение, к которому привязывают чат.
+func getMatter(ctx context.Context, q sqlx.QueryerContext, matterID int64) (*matters.Summary, error) {
+	var row matters.SummaryRow
+	err := sqlx.GetContext(ctx, q, &row, `SELECT id, title, status FROM matters WHERE id = $1`, matterID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, matters.ErrMatterNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("select matter: %w", err)
+	}
+	return row.Summary(), nil
+}
+
+// getOpenMatter — getMatter для новых привязок: в закрытое поручение чаты
+// не добавляют.
+func getOpenMatter(ctx context.Context, q sqlx.QueryerContext, matterID int64) (*matters.Summary, error) {
+	matter, err := getMatter(ctx, q, matterID)
+	if err != nil {
+		return nil, err
+	}
+	if matter.Status == matters.StatusClosed {
+		return nil, matters.ErrMatterClosed
+	}
+	return matter, nil
+}
+
+// checkAvatar: аватар чата должен быть подтверждённой загрузкой картинки,
+// сделанной тем, кто его ставит, — как вложения сообщений.
+func checkAvatar(ctx context.Context, q sqlx.QueryerContext, actorID int64, fileID *string) error {
+	if fileID == nil || *fileID == "" {
+		return nil
+	}
+
+	var ok bool
+	if err := sqlx.GetContext(ctx, q, &ok, `
+		SELECT EXISTS (
+			SELECT 1 FROM uploads
+			WHERE file_id = $1 AND owner_user_id = $2 AND status = $3 AND content_type LIKE 'image/%'
+		)
+	`, *fileID, actorID, uploadsdomain.StatusReady); err != nil {
+		return fmt.Errorf("check avatar: %w", err)
+	}
+
+	if !ok {
+		return chats.ErrInvalidAvatar
+	}
+
+	return nil
+}
+
+func sameString(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// GetOrCreateDirectChat возвращает личный чат userID и peerID, создавая его
+// при первом обращении. Оба собеседника в нём равноправные peer (см. RolePeer).
+func (s *Repo) GetOrCreateDirectChat(ctx context.Context, userID, peerID int64) (*chats.ChatInfo, bool, error) {
+	const op = "storage.postgres.GetOrCreateDirectChat"
+
+	if peerID <= 0 || peerID == userID {
+		return nil, false, chats.ErrInvalidDirectPeer
+	}
+
+	if _, err := s.usersRepo.GetUser(ctx, peerID); err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			return nil, false, chats.ErrInvalidDirectPeer
+		}
+		return nil, false, fmt.Errorf("%s: get peer: %w", op, err)
+	}
+
+	low, high := min(userID, peerID), max(userID, peerID)
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: begin: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// При гонке второй запрос ждёт коммита первого на уникальном индексе
+	// и получает DO NOTHING, после чего видит уже созданный чат
+	var chatID int64
+	created := true
+	err = tx.GetContext(ctx, &chatID, `
+		INSERT INTO chats (type, direct_user_low, direct_user_high)
+		VALUES ('direct', $1, $2)
+		ON CONFLICT (direct_user_low, direct_user_high) WHERE type = 'direct' DO NOTHING
+		RETURNING id
+	`, low, high)
+	if errors.Is(err, sql.ErrNoRows) {
+		created = false
+		err = tx.GetContext(ctx, &chatID, `
+			SELECT id FROM chats
+			WHERE type = 'direct' AND direct_user_low = $1 AND direct_user_high = $2
+		`, low, high)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: get or insert chat: %w", op, err)
+	}
+
+	if created {
+		if _, err := s.addChatParticipants(ctx, tx, chatID, []int64{low, high}, chats.RolePeer); err != nil {
+			return nil, false, fmt.Errorf("%s: add participants: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("%s: commit: %w", op, err)
+	}
+
+	chat, err := s.GetChat(ctx, chatID, userID)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return chat, created, nil
+}
+
 func (s *Repo) AddChatParticipants(ctx context.Context, chatID int64, userIDs []int64) ([]users.User, error) {
-	return s.addChatParticipants(ctx, s.db, chatID, userIDs)
+	if err := ensureGroupChat(ctx, s.db, chatID); err != nil {
+		return nil, fmt.Errorf("storage.postgres.AddChatParticipants: %w", err)
+	}
+	return s.addChatParticipants(ctx, s.db, chatID, userIDs, chats.RoleMember)
+}
+
+// ensureGroupChat: у личного чата состав и оформление не меняются.
+func ensureGroupChat(ctx context.Context, q sqlx.QueryerContext, chatID int64) error {
+	var typ chats.ChatType
+	err := sqlx.GetContext(ctx, q, &typ, `SELECT type FROM chats WHERE id = $1`, chatID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return chats.ErrChatNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("select chat type: %w", err)
+	}
+
+	if typ == chats.ChatTypeDirect {
+		return chats.ErrDirectChat
+	}
+
+	return nil
 }
 
 func (s *Repo) addChatParticipants(
@@ -69,6 +332,7 @@ func (s *Repo) addChatParticipants(
 	q sqlx.ExtContext,
 	chatID int64,
 	userIDs []int64,
+	role chats.Role,
 ) ([]users.User, error) {
 
 	const op = "storage.postgres.AddChatParticipants"
@@ -80,18 +344,24 @@ func (s *Repo) addChatParticipants(
 	userIDs = uniquePositiveInts(userIDs)
 
 	query := `
-			INSERT INTO chat_participants (chat_id, user_id)
-			VALUES ($1, $2)
+			INSERT INTO chat_participants (chat_id, user_id, role)
+			VALUES ($1, $2, $3)
 			ON CONFLICT (chat_id, user_id) DO NOTHING
     `
 
 	users := make([]users.User, 0, len(userIDs))
 
 	for _, userID := range userIDs {
-		if _, err := q.ExecContext(ctx, query, chatID, userID); err != nil {
+		res, err := q.ExecContext(ctx, query, chatID, userID, role)
+		if err != nil {
 			return nil, fmt.Errorf("%s: insert user %d: %w", op, userID, err)
 		}
 
+		// Уже состоит в чате — в ответ не попадает
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+
 		u, err := s.usersRepo.GetUser(ctx, userID)
 		if err != nil {
 			return nil, fmt.Errorf("%s: get user %d: %w", op, userID, err)
@@ -120,84 +390,130 @@ func uniquePositiveInts(input []int64) []int64 {
 	return result
 }
 
-func containsAttachment(attachments []uploadsdomain.AttachmentRow, att uploadsdomain.AttachmentRow) bool {
-	for _, a := range attachments {
-		if a.FileID.String == att.FileID.String {
-			return true
-		}
-	}
-	return false
+// GetChats: закреплённые чаты идут первыми, от последнего закреплённого,
+// остальные — по последней активности.
+func (s *Repo) GetChats(ctx context.Context, userID int64, params chats.GetChatsParams) (*chats.ChatsPage, error) {
+	return s.getChats(ctx, userID, params)
 }
 
-func containsUser(users []users.User, user users.User) bool {
-	for _, u := range users {
-		if u.ID == user.ID {
-			return true
-		}
+func (s *Repo) GetMatterChats(ctx context.Context, userID int64, params chats.GetChatsParams) (*chats.ChatsPage, error) {
+	const op = "storage.postgres.GetMatterChats"
+
+	if params.MatterID == nil {
+		return nil, fmt.Errorf("%s: %w", op, matters.ErrInvalidMatterID)
+	}
+
+	var exists bool
+	if err := s.db.GetContext(ctx, &exists, `SELECT EXISTS (SELECT 1 FROM matters WHERE id = $1)`, *params.MatterID); err != nil {
+		return nil, fmt.Errorf("%s: select matter: %w", op, err)
+	}
+	if !exists {
+		return nil, matters.ErrMatterNotFound
 	}
-	return false
+
+	return s.getChats(ctx, userID, params)
 }
 
-func (s *Repo) GetChats(ctx context.Context, userID int64) ([]chats.ChatListItem, error) {
+// getChats сначала выбирает страницу чатов (limit+1 строк), и только для неё
+// достаёт последнее сообщение, счётчики и участников. Саму страницу индекс не
+// отдаёт: порядок pinned_at, last_activity_at и фильтр непрочитанных требуют
+// перебрать и отсортировать все чаты пользователя. Так стоимость запроса
+// ограничена числом его чатов, но не объёмом сообщений в них.
+func (s *Repo) getChats(ctx context.Context, userID int64, params chats.GetChatsParams) (*chats.ChatsPage, error) {
 	const op = "storage.postgres.GetChats"
 
+	var (
+		cursorPinnedAt sql.NullTime
+		cursorAt       sql.NullTime
+		cursorID       sql.NullInt64
+		chatType       sql.NullString
+		query          sql.NullString
+	)
+	if c := params.Cursor; c != nil {
+		if c.PinnedAt != nil {
+			cursorPinnedAt = sql.NullTime{Time: *c.PinnedAt, Valid: true}
+		}
+		cursorAt = sql.NullTime{Time: c.ActivityAt, Valid: true}
+		cursorID = sql.NullInt64{Int64: c.ChatID, Valid: true}
+	}
+	if params.Type != nil {
+		chatType = sql.NullString{String: string(*params.Type), Valid: true}
+	}
+	if params.Query != "" {
+		query = sql.NullString{String: "%" + escapeLike(params.Query) + "%", Valid: true}
+	}
+
 	rows, err := s.db.QueryxContext(
 		ctx,
 		`
-		WITH my_participation AS (SELECT chat_id,
-                                user_id,
-                                CASE
-                                    WHEN last_read_message_id IS NULL OR last_read_message_id < 0 THEN 0
-                                    ELSE last_read_message_id
-                                    END AS last_read_message_id
-                          FROM chat_participants
-                          WHERE user_id = $1),
-
-    last_message AS (SELECT chat_id,
-                            id,
-                            sender_user_id,
-                            text,
-                            created_at
-                      FROM (SELECT m.chat_id,
-                                  m.id,
-                                  m.sender_user_id,
-                                  m.text,
-                                  m.created_at,
-                                  ROW_NUMBER() OVER (
-                                      PARTITION BY m.chat_id
-                                      ORDER BY m.created_at DESC, m.id DESC
-                                      ) AS rn
-                            FROM messages m)
-                      WHERE rn = 1),
-
-    unread_counts AS (SELECT mp.chat_id,
-                              COUNT(*) AS unread_count
-                      FROM messages m
-                                JOIN my_participation mp ON mp.chat_id = m.chat_id
-                      WHERE m.id > mp.last_read_message_id
-                        AND m.sender_user_id <> mp.user_id
-                      GROUP BY mp.chat_id),
-
-    others_max_read AS (SELECT cp.chat_id,
-                                COALESCE(MAX(
-                                                CASE
-                                                    WHEN cp.last_read_message_id IS NULL OR cp.last_read_message_id < 0
-                                                        THEN 0
-                                                    ELSE cp.last_read_message_id
-                                                    END
-                                        ), 0) AS others_max_last_read_message_id
-                        FROM chat_participants cp
-                                  JOIN my_participation mp ON mp.chat_id = cp.chat_id
-                        WHERE cp.user_id <> mp.user_id
-                        GROUP BY cp.chat_id)
-
-		SELECT cp.chat_id                                         AS "chat_id",
-      cp.user_id                                         AS "user_id",
+		WITH page AS (SELECT cp.chat_id,
+                     GREATEST(COALESCE(cp.last_read_message_id, 0), 0) AS last_read_message_id,
+                     cp.muted_until,
+                     cp.pinned_at,
+                     cp.archived_at,
+                     c.type,
+                     c.title,
+                     c.description,
+                     c.avatar_file_id,
+                     c.matter_id,
+                     c.last_activity_at
+              FROM chat_participants cp
+                       JOIN chats c ON c.id = cp.chat_id
+              WHERE cp.user_id = $1
+                AND ($2::bigint IS NULL OR c.matter_id = $2)
+                AND ($3::boolean IS NULL OR (cp.archived_at IS NOT NULL) = $3)
+                AND ($4::text IS NULL OR c.type = $4)
+                AND ($5::text IS NULL
+                     OR c.title ILIKE $5
+                     OR EXISTS (SELECT 1
+                                FROM chat_participants op
+                                         JOIN users u ON u.id = op.user_id
+                                WHERE op.chat_id = cp.chat_id
+                                  AND op.user_id <> cp.user_id
+                                  AND u.name ILIKE $5))
+                AND (NOT $6::boolean
+                     OR EXISTS (SELECT 1
+                                FROM messages m
+                                WHERE m.chat_id = cp.chat_id
+                                  AND m.id > COALESCE(cp.last_read_message_id, 0)
+                                  AND m.sender_user_id <> cp.user_id
+                                  AND m.kind = 'user'
+                                  AND m.deleted_at IS NULL
+                                  AND NOT EXISTS (SELECT 1 FROM message_hidden h
+                                                  WHERE h.user_id = cp.user_id AND h.message_id = m.id)))
+                AND ($9::bigint IS NULL
+                     OR (cp.pinned_at IS NOT NULL, COALESCE(cp.pinned_at, '-infinity'), c.last_activity_at, c.id)
+                        < ($7::timestamptz IS NOT NULL, COALESCE($7::timestamptz, '-infinity'), $8::timestamptz, $9::bigint))
+              ORDER BY cp.pinned_at IS NOT NULL DESC,
+                       COALESCE(cp.pinned_at, '-infinity') DESC,
+                       c.last_activity_at DESC,
+                       c.id DESC
+              LIMIT $10)
+
+		SELECT p.chat_id                                          AS "chat_id",
+      p.type                                             AS "type",
+      p.last_activity_at                                 AS "last_activity_at",
+      p.title                                            AS "title",
+      p.description                                      AS "description",
+      p.avatar_file_id                                   AS "avatar_file_id",
+      mt.id                                              AS "matter.id",
+      mt.title                                           AS "matter.title",
+      mt.status                                          AS "matter.status",
+      p.muted_until                                      AS "settings.muted_until",
+      p.pinned_at                                        AS "settings.pinned_at",
+      p.archived_at                                      AS "settings.archived_at",
 
       lm.id                                AS "last_message.id",
       lm.sender_user_id                     AS "last_message.sender_user_id",
+      lm.kind                              AS "last_message.kind",
+      lm.payload                           AS "last_message.payload",
       lm.text                              AS "last_message.text",
       lm.created_at AS "last_message.created_at",
+      lm.edited_at AS "last_message.edited_at",
+      lm.deleted_at AS "last_message.deleted_at",
+      lm.forwarded_from_user_id AS "last_message.forwarded_from.user_id",
+      lm.forwarded_from_chat_id AS "last_message.forwarded_from.chat_id",
+      lm.forwarded_from_message_id AS "last_message.forwarded_from.message_id",
 
       att.file_id                         AS "last_message.attachment.file_id",
       att.content_type                    AS "last_message.attachment.content_type",
@@ -208,23 +524,52 @@ func (s *Repo) GetChats(ctx context.Context, userID int64) ([]chats.ChatListItem
 			att.duration_ms                     AS "last_message.attachment.duration_ms",
 			att.waveform_u8                     AS "last_message.attachment.waveform_u8",
 
-      COALESCE(uc.unread_count, 0)                       AS "unread_count",
+      uc.unread_count                                    AS "unread_count",
       om.others_max_last_read_message_id    AS "others_max_last_read_message_id"
 
-		FROM chat_participants cp
-        JOIN my_participation mp ON mp.chat_id = cp.chat_id
-        LEFT JOIN last_message lm ON lm.chat_id = cp.chat_id
-        LEFT JOIN unread_counts uc ON uc.chat_id = cp.chat_id
-        LEFT JOIN others_max_read om ON om.chat_id = cp.chat_id
-        LEFT JOIN attachments att ON att.message_id = lm.id
-
-		ORDER BY CASE WHEN lm.created_at IS NULL THEN 1 ELSE 0 END,
-        lm.created_at DESC,
-        lm.id DESC,
-        cp.chat_id,
-        cp.user_id
+		FROM page p
+        LEFT JOIN matters mt ON mt.id = p.matter_id
+        LEFT JOIN LATERAL (SELECT m.id,
+                                  m.sender_user_id,
+                                  m.kind,
+                                  m.payload,
+                                  CASE WHEN m.deleted_at IS NULL THEN m.text ELSE '' END AS text,
+                                  m.created_at,
+                                  m.edited_at,
+                                  m.deleted_at,
+                                  m.forwarded_from_user_id,
+                                  m.forwarded_from_chat_id,
+                                  m.forwarded_from_message_id
+                           FROM messages m
+                           WHERE m.chat_id = p.chat_id
+                             AND NOT EXISTS (SELECT 1 FROM message_hidden h
+                                             WHERE h.user_id = $1 AND h.message_id = m.id)
+                           ORDER BY m.created_at DESC, m.id DESC
+                           LIMIT 1) lm ON true
+        CROSS JOIN LATERAL (SELECT COUNT(*) AS unread_count
+                            FROM messages m
+                            WHERE m.chat_id = p.chat_id
+                              AND m.id > p.last_read_message_id
+                              AND m.sender_user_id <> $1
+                              AND m.kind = 'user'
+                              AND m.deleted_at IS NULL
+                              AND NOT EXISTS (SELECT 1 FROM message_hidden h
+                                              WHERE h.user_id = $1 AND h.message_id = m.id)) uc
+        CROSS JOIN LATERAL (SELECT COALESCE(MAX(GREATEST(COALESCE(cp.last_read_message_id, 0), 0)), 0)
+                                       AS others_max_last_read_message_id
+                            FROM chat_participants cp
+                            WHERE cp.chat_id = p.chat_id
+                              AND cp.user_id <> $1) om
+        LEFT JOIN attachments att ON att.message_id = lm.id AND lm.deleted_at IS NULL
+
+		ORDER BY p.pinned_at IS NOT NULL DESC,
+        COALESCE(p.pinned_at, '-infinity') DESC,
+        p.last_activity_at DESC,
+        p.chat_id DESC,
+        att.id
 		`,
-		userID,
+		userID, params.MatterID, params.Archived, chatType, query, params.UnreadOnly,
+		cursorPinnedAt, cursorAt, cursorID, params.Limit+1,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("%s: query: %w", op, err)
@@ -234,75 +579,46 @@ func (s *Repo) GetChats(ctx context.Context, userID int64) ([]chats.ChatListItem
 	chatList := []chats.ChatListItem{}
 
 	var (
-		currentUsers                  []users.User
-		lastMessageRow                messagesdomain.ChatLastMessageRow
-		lastMessageAttachments        []uploadsdomain.AttachmentRow
-		lastMessageReplyToAttachments []uploadsdomain.AttachmentRow
-		lastChatID                    int64
-		unreadCount                   int64
-		othersMaxLastReadMessageID    int64
-		hasLast                       bool
+		current                *chats.ChatRow
+		lastMessageAttachments []uploadsdomain.AttachmentRow
 	)
 
+	flush := func() {
+		var lm *messagesdomain.Message
+		if lmRow := messagesdomain.NewMessageFromChatRow(current.LastMessage); lmRow != nil {
+			lmsg := messagesdomain.NewMessageFromRow(*lmRow, slices.Clone(lastMessageAttachments), nil)
+			lm = &lmsg
+		}
+		chatList = append(chatList, chats.ChatListItem{
+			ID:                         current.ChatID,
+			Type:                       current.Type,
+			LastActivityAt:             current.LastActivityAt,
+			LastMessage:                lm,
+			UnreadCount:                current.UnreadCount,
+			OthersMaxLastReadMessageID: current.OthersMaxLastReadMessageID,
+			Matter:                     current.Matter.Summary(),
+			Settings:                   current.Settings,
+			ChatMeta:                   current.ChatMeta,
+		})
+		lastMessageAttachments = lastMessageAttachments[:0]
+	}
+
 	for rows.Next() {
 		var row chats.ChatRow
 		if err := rows.StructScan(&row); err != nil {
 			return nil, fmt.Errorf("%s: scan: %w", op, err)
 		}
 
-		if !hasLast {
-			hasLast = true
-			lastChatID = row.ChatID
-			unreadCount = row.UnreadCount
-			othersMaxLastReadMessageID = row.OthersMaxLastReadMessageID
-			lastMessageRow = row.LastMessage
-		}
-
-		if row.ChatID != lastChatID {
-			lmRow := messagesdomain.NewMessageFromChatRow(lastMessageRow)
-			var lm *messagesdomain.Message
-			if lmRow != nil {
-				lmsg := messagesdomain.NewMessageFromRow(
-					*lmRow,
-					slices.Clone(lastMessageAttachments),
-					slices.Clone(lastMessageReplyToAttachments),
-				)
-				lm = &lmsg
+		// у последнего сообщения может быть несколько вложений — по строке на каждое
+		if current == nil || row.ChatID != current.ChatID {
+			if current != nil {
+				flush()
 			}
-			chatList = append(chatList, chats.ChatListItem{
-				ID:                         lastChatID,
-				Users:                      slices.Clone(currentUsers),
-				LastMessage:                lm,
-				UnreadCount:                unreadCount,
-				OthersMaxLastReadMessageID: othersMaxLastReadMessageID,
-			})
-
-			currentUsers = currentUsers[:0]
-			lastMessageAttachments = lastMessageAttachments[:0]
-			lastMessageReplyToAttachments = lastMessageReplyToAttachments[:0]
-			lastChatID = row.ChatID
-			unreadCount = row.UnreadCount
-			othersMaxLastReadMessageID = row.OthersMaxLastReadMessageID
-			lastMessageRow = row.LastMessage
-		}
-		user, err := s.usersRepo.GetUser(ctx, row.UserID)
-		if err != nil {
-			return nil, fmt.Errorf("%s: get user: %w", op, err)
-		}
-		if !containsUser(currentUsers, user) {
-			currentUsers = append(currentUsers, user)
+			current = &row
 		}
 
-		// Добавляем attachment только если он валидный и ещё не добавлен
 		if row.LastMessage.Attachment.FileID.Valid {
-			if !containsAttachment(lastMessageAttachments, row.LastMessage.Attachment) {
-				lastMessageAttachments = append(lastMessageAttachments, row.LastMessage.Attachment)
-			}
-		}
-		if row.LastMessage.ReplyToAttachment.FileID.Valid {
-			if !containsAttachment(lastMessageReplyToAttachments, row.LastMessage.ReplyToAttachment) {
-				lastMessageReplyToAttachments = append(lastMessageReplyToAttachments, row.LastMessage.ReplyToAttachment)
-			}
+			lastMessageAttachments = append(lastMessageAttachments, row.LastMessage.Attachment)
 		}
 	}
 
@@ -310,37 +626,120 @@ func (s *Repo) GetChats(ctx context.Context, userID int64) ([]chats.ChatListItem
 		return nil, fmt.Errorf("%s: rows: %w", op, err)
 	}
 
-	if hasLast {
-		lmRow := messagesdomain.NewMessageFromChatRow(lastMessageRow)
-		var lm *messagesdomain.Message
-		if lmRow != nil {
-			lmsg := messagesdomain.NewMessageFromRow(
-				*lmRow,
-				slices.Clone(lastMessageAttachments),
-				slices.Clone(lastMessageReplyToAttachments),
-			)
-			lm = &lmsg
+	if current != nil {
+		flush()
+	}
+
+	page := &chats.ChatsPage{Chats: chatList}
+	if len(page.Chats) > params.Limit {
+		page.Chats = page.Chats[:params.Limit]
+		next := chats.NewChatsCursor(page.Chats[len(page.Chats)-1])
+		page.NextCursor = &next
+	}
+
+	if err := s.attachChatUsers(ctx, page.Chats); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := s.attachLastMessageReactions(ctx, userID, page.Chats); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return page, nil
+}
+
+// attachChatUsers заполняет участников чатов страницы: одним запросом
+// chat_participants и одним GetUsers на всех.
+func (s *Repo) attachChatUsers(ctx context.Context, chatList []chats.ChatListItem) error {
+	if len(chatList) == 0 {
+		return nil
+	}
+
+	chatIDs := make([]int64, 0, len(chatList))
+	for _, c := range chatList {
+		chatIDs = append(chatIDs, c.ID)
+	}
+
+	var participants []struct {
+		ChatID int64 `db:"chat_id"`
+		UserID int64 `db:"user_id"`
+	}
+	if err := s.db.SelectContext(ctx, &participants, `
+		SELECT chat_id, user_id
+		FROM chat_participants
+		WHERE chat_id = ANY($1)
+		ORDER BY chat_id, joined_at, user_id
+	`, pq.Array(chatIDs)); err != nil {
+		return fmt.Errorf("select participants: %w", err)
+	}
+
+	userIDs := make([]int64, 0, len(participants))
+	for _, p := range participants {
+		if !slices.Contains(userIDs, p.UserID) {
+			userIDs = append(userIDs, p.UserID)
 		}
+	}
 
-		chatList = append(chatList, chats.ChatListItem{
-			ID:                         lastChatID,
-			Users:                      slices.Clone(currentUsers),
-			LastMessage:                lm,
-			UnreadCount:                unreadCount,
-			OthersMaxLastReadMessageID: othersMaxLastReadMessageID,
-		})
+	list, err := s.usersRepo.GetUsers(ctx, userIDs)
+	if err != nil {
+		return fmt.Errorf("get users: %w", err)
+	}
+	usersByID := make(map[int64]users.User, len(list))
+	for _, u := range list {
+		usersByID[u.ID] = u
+	}
+
+	chatUsers := make(map[int64][]users.User, len(chatList))
+	for _, p := range participants {
+		chatUsers[p.ChatID] = append(chatUsers[p.ChatID], usersByID[p.UserID])
+	}
+	for i := range chatList {
+		chatList[i].Users = chatUsers[chatList[i].ID]
+		if chatList[i].Users == nil {
+			chatList[i].Users = []users.User{}
+		}
+	}
+
+	return nil
+}
+
+// escapeLike экранирует спецсимволы LIKE, чтобы поиск шёл по подстроке как есть.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (s *Repo) attachLastMessageReactions(ctx context.Context, userID int64, chatList []chats.ChatListItem) error {
+	ids := make([]int64, 0, len(chatList))
+	for _, c := range chatList {
+		if c.LastMessage != nil {
+			ids = append(ids, c.LastMessage.ID)
+		}
+	}
+
+	reactions, err := s.messages.GetReactions(ctx, userID, ids)
+	if err != nil {
+		return fmt.Errorf("get reactions: %w", err)
 	}
 
-	return chatList, nil
+	for _, c := range chatList {
+		if c.LastMessage == nil {
+			continue
+		}
+		if rs, ok := reactions[c.LastMessage.ID]; ok {
+			c.LastMessage.Reactions = rs
+		}
+	}
+
+	return nil
 }
 
-func (s *Repo) GetChat(ctx context.Context, chatID int64) (*chats.ChatInfo, error) {
+func (s *Repo) GetChat(ctx context.Context, chatID, viewerID int64) (*chats.ChatInfo, error) {
 	const op = "storage.postgres.GetChat"
 
 	rows, err := s.db.QueryContext(
 		ctx,
 		`
-		SELECT chat_id, user_id
+		SELECT chat_id, user_id, role
 		FROM chat_participants
 		WHERE chat_id = $1
 		`,
@@ -353,14 +752,17 @@ func (s *Repo) GetChat(ctx context.Context, chatID int64) (*chats.ChatInfo, erro
 
 	var userIDs []int64
 	var foundChatID int64
+	roles := map[int64]chats.Role{}
 
 	for rows.Next() {
 		var cID, uID int64
-		if err := rows.Scan(&cID, &uID); err != nil {
+		var role chats.Role
+		if err := rows.Scan(&cID, &uID, &role); err != nil {
 			return nil, fmt.Errorf("%s: scan error: %w", op, err)
 		}
 		foundChatID = cID
 		userIDs = append(userIDs, uID)
+		roles[uID] = role
 	}
 
 	if err := rows.Err(); err != nil {
@@ -376,12 +778,330 @@ func (s *Repo) GetChat(ctx context.Context, chatID int64) (*chats.ChatInfo, erro
 		return nil, fmt.Errorf("%s: get users error: %w", op, err)
 	}
 
+	var chat struct {
+		Type   chats.ChatType     `db:"type"`
+		Matter matters.SummaryRow `db:"matter"`
+		chats.ChatMeta
+	}
+	if err := s.db.GetContext(ctx, &chat, `
+		SELECT c.type, c.title, c.description, c.avatar_file_id,
+		       mt.id AS "matter.id", mt.title AS "matter.title", mt.status AS "matter.status"
+		FROM chats c
+		LEFT JOIN matters mt ON mt.id = c.matter_id
+		WHERE c.id = $1
+	`, chatID); err != nil {
+		return nil, fmt.Errorf("%s: select chat: %w", op, err)
+	}
+
+	pin, err := s.messages.GetLatestPin(ctx, chatID, viewerID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
 	return &chats.ChatInfo{
-		ID:    foundChatID,
-		Users: users,
+		ID:        foundChatID,
+		Type:      chat.Type,
+		Users:     users,
+		Roles:     roles,
+		Matter:    chat.Matter.Summary(),
+		LatestPin: pin,
+		ChatMeta:  chat.ChatMeta,
 	}, nil
 }
 
+// GetParticipantRoles возвращает роль userID в каждом из chatIDs, где он участник.
+func (s *Repo) GetParticipantRoles(ctx context.Context, userID int64, chatIDs []int64) (map[int64]chats.Role, error) {
+	const op = "storage.postgres.GetParticipantRoles"
+
+	result := map[int64]chats.Role{}
+	if len(chatIDs) == 0 {
+		return result, nil
+	}
+
+	var rows []struct {
+		ChatID int64      `db:"chat_id"`
+		Role   chats.Role `db:"role"`
+	}
+	err := s.db.SelectContext(
+		ctx,
+		&rows,
+		`
+		SELECT chat_id, role
+		FROM chat_participants
+		WHERE user_id = $1 AND chat_id = ANY($2)
+		`,
+		userID, pq.Array(chatIDs),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: select: %w", op, err)
+	}
+
+	for _, r := range rows {
+		result[r.ChatID] = r.Role
+	}
+
+	return result, nil
+}
+
+// SetParticipantRole меняет роль участника на admin или member.
+// Роль владельца так поменять нельзя.
+func (s *Repo) SetParticipantRole(ctx context.Context, chatID, userID int64, role chats.Role) error {
+	const op = "storage.postgres.SetParticipantRole"
+
+	if role != chats.RoleAdmin && role != chats.RoleMember {
+		return chats.ErrInvalidRole
+	}
+
+	if err := ensureGroupChat(ctx, s.db, chatID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	var current chats.Role
+	err := s.db.GetContext(ctx, &current, `
+		SELECT role FROM chat_participants WHERE chat_id = $1 AND user_id = $2
+	`, chatID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return chats.ErrNotChatMember
+	}
+	if err != nil {
+		return fmt.Errorf("%s: select: %w", op, err)
+	}
+
+	if current == chats.RoleOwner {
+		return chats.ErrOwnerRoleChange
+	}
+
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE chat_participants SET role = $3 WHERE chat_id = $1 AND user_id = $2
+	`, chatID, userID, role); err != nil {
+		return fmt.Errorf("%s: update: %w", op, err)
+	}
+
+	return nil
+}
+
+// RemoveChatParticipant исключает участника из чата. Владельца исключить нельзя.
+func (s *Repo) RemoveChatParticipant(ctx context.Context, chatID, userID int64) error {
+	const op = "storage.postgres.RemoveChatParticipant"
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: begin: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := ensureGroupChat(ctx, tx, chatID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	role, err := participantRoleForUpdate(ctx, tx, chatID, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if role == chats.RoleOwner {
+		return chats.ErrOwnerRemoval
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM chat_participants WHERE chat_id = $1 AND user_id = $2
+	`, chatID, userID); err != nil {
+		return fmt.Errorf("%s: delete: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: commit: %w", op, err)
+	}
+
+	return nil
+}
+
+// LeaveChat удаляет userID из чата. Если он был владельцем, владение
+// переходит к самому давнему админу, а без админов — к самому давнему
+// участнику. Возвращает id нового владельца или 0.
+func (s *Repo) LeaveChat(ctx context.Context, chatID, userID int64) (int64, error) {
+	const op = "storage.postgres.LeaveChat"
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: begin: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := ensureGroupChat(ctx, tx, chatID); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	role, err := participantRoleForUpdate(ctx, tx, chatID, userID)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM chat_participants WHERE chat_id = $1 AND user_id = $2
+	`, chatID, userID); err != nil {
+		return 0, fmt.Errorf("%s: delete: %w", op, err)
+	}
+
+	var newOwnerID int64
+	if role == chats.RoleOwner {
+		err := tx.GetContext(ctx, &newOwnerID, `
+			UPDATE chat_participants SET role = 'owner'
+			WHERE chat_id = $1 AND user_id = (
+				SELECT user_id FROM chat_participants
+				WHERE chat_id = $1
+				ORDER BY role = 'admin' DESC, joined_at, user_id
+				LIMIT 1
+			)
+			RETURNING user_id
+		`, chatID)
+		// Вышел последний участник — передавать некому
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%s: transfer ownership: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: commit: %w", op, err)
+	}
+
+	return newOwnerID, nil
+}
+
+func participantRoleForUpdate(ctx context.Context, tx *sqlx.Tx, chatID, userID int64) (chats.Role, error) {
+	var role chats.Role
+	err := tx.GetContext(ctx, &role, `
+		SELECT role FROM chat_participants
+		WHERE chat_id = $1 AND user_id = $2
+		FOR UPDATE
+	`, chatID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", chats.ErrNotChatMember
+	}
+	if err != nil {
+		return "", fmt.Errorf("select role: %w", err)
+	}
+
+	return role, nil
+}
+
+// UpdateChatSettings меняет личные настройки чата у userID. Повторное
+// закрепление или архивирование не сдвигает исходное время.
+func (s *Repo) UpdateChatSettings(
+	ctx context.Context,
+	chatID,
+	userID int64,
+	req chats.UpdateChatSettingsRequest,
+) (chats.ChatSettings, error) {
+
+	const op = "storage.postgres.UpdateChatSettings"
+
+	// muted_until без muted тоже означает "заглушить"
+	muted, mutedUntil := req.Muted, req.MutedUntil
+	if muted == nil && mutedUntil != nil {
+		muted = new(bool)
+		*muted = true
+	}
+	if muted != nil && *muted && mutedUntil == nil {
+		mutedUntil = &chats.MutedForever
+	}
+
+	var settings chats.ChatSettings
+	err := s.db.GetContext(ctx, &settings, `
+		UPDATE chat_participants
+		SET muted_until = CASE WHEN $3::boolean IS NULL THEN muted_until
+		                       WHEN $3 THEN $4::timestamptz
+		                       ELSE NULL END,
+		    pinned_at   = CASE WHEN $5::boolean IS NULL THEN pinned_at
+		                       WHEN $5 THEN COALESCE(pinned_at, now())
+		                       ELSE NULL END,
+		    archived_at = CASE WHEN $6::boolean IS NULL THEN archived_at
+		                       WHEN $6 THEN COALESCE(archived_at, now())
+		                       ELSE NULL END
+		WHERE chat_id = $1 AND user_id = $2
+		RETURNING muted_until, pinned_at, archived_at
+	`, chatID, userID, muted, mutedUntil, req.Pinned, req.Archived)
+	if errors.Is(err, sql.ErrNoRows) {
+		return chats.ChatSettings{}, chats.ErrNotChatMember
+	}
+	if err != nil {
+		return chats.ChatSettings{}, fmt.Errorf("%s: update: %w", op, err)
+	}
+
+	return settings, nil
+}
+
+func (s *Repo) IsChatParticipant(ctx context.Context, chatID, userID int64) (bool, error) {
+	const op = "storage.postgres.IsChatParticipant"
+
+	var exists bool
+	err := s.db.GetContext(
+		ctx,
+		&exists,
+		`
+		SELECT EXISTS (
+			SELECT 1
+			FROM chat_participants
+			WHERE chat_id = $1 AND user_id = $2
+		)
+		`,
+		chatID, userID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("%s: select: %w", op, err)
+	}
+
+	return exists, nil
+}
+
+// FilterParticipantChats возвращает те chatIDs, в которых userID является участником.
+func (s *Repo) FilterParticipantChats(ctx context.Context, userID int64, chatIDs []int64) ([]int64, error) {
+	const op = "storage.postgres.FilterParticipantChats"
+
+	result := []int64{}
+	if len(chatIDs) == 0 {
+		return result, nil
+	}
+
+	err := s.db.SelectContext(
+		ctx,
+		&result,
+		`
+		SELECT chat_id
+		FROM chat_participants
+		WHERE user_id = $1 AND chat_id = ANY($2)
+		`,
+		userID, pq.Array(chatIDs),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: select: %w", op, err)
+	}
+
+	return result, nil
+}
+
+func (s *Repo) GetChatPartnerIDs(ctx context.Context, userID int64) ([]int64, error) {
+	const op = "storage.postgres.GetChatPartnerIDs"
+
+	ids := []int64{}
+	err := s.db.SelectContext(
+		ctx,
+		&ids,
+		`
+		SELECT DISTINCT other.user_id
+		FROM chat_participants me
+		JOIN chat_participants other ON other.chat_id = me.chat_id
+		WHERE me.user_id = $1 AND other.user_id <> $1
+		`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: select: %w", op, err)
+	}
+
+	return ids, nil
+}
+
 func (s *Repo) GetUnreadMessagesCount(ctx context.Context, userID int64) (int, error) {
 
 	const op = "storage.postgres.GetUnreadMessagesCount"
@@ -395,7 +1115,11 @@ func (s *Repo) GetUnreadMessagesCount(ctx context.Context, userID int64) (int, e
 		ON m.chat_id = cp.chat_id
 		WHERE cp.user_id = $1
 		AND m.sender_user_id <> $1
+		AND m.kind = 'user'
+		AND (cp.muted_until IS NULL OR cp.muted_until <= now())
 		AND m.id > COALESCE(cp.last_read_message_id, 0)
+		AND m.deleted_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.user_id = $1 AND h.message_id = m.id)
 		`,
 		userID,
 	).Scan(&unreadCount)
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/kgellert/hodatay-messenger/internal/chats"
 	"github.com/kgellert/hodatay-messenger/internal/users"
 )
 
@@ -18,6 +19,7 @@ func TestRepo_addChatParticipants(t *testing.T) {
 		q       sqlx.ExtContext
 		chatID  int64
 		userIDs []int64
+		role    chats.Role
 		want    []users.User
 		wantErr bool
 	}{
@@ -25,8 +27,8 @@ func TestRepo_addChatParticipants(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := New(tt.db, tt.usersRepo)
-			got, gotErr := s.addChatParticipants(context.Background(), tt.q, tt.chatID, tt.userIDs)
+			s := New(tt.db, tt.usersRepo, nil)
+			got, gotErr := s.addChatParticipants(context.Background(), tt.q, tt.chatID, tt.userIDs, tt.role)
 			if gotErr != nil {
 				if !tt.wantErr {
 					t.Errorf("addChatParticipants() failed: %v", gotErr)
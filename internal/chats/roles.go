@@ -0,0 +1,66 @@
+package chats
+
+import "slices"
+
+// Role — роль участника в конкретном чате.
+type Role string
+
+const (
+	RoleOwner  Role = "owner"
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member"
+	// RolePeer — собеседник в личном чате: оба равны. Выйти из личного чата
+	// нельзя, поэтому удалить его может любой из двоих; чужие сообщения — нет
+	RolePeer Role = "peer"
+)
+
+func (r Role) IsValid() bool {
+	switch r {
+	case RoleOwner, RoleAdmin, RoleMember, RolePeer:
+		return true
+	}
+	return false
+}
+
+// Permission — действие в чате, которое доступно не каждому участнику.
+type Permission string
+
+const (
+	PermDeleteChat           Permission = "delete_chat"
+	PermAddParticipants      Permission = "add_participants"
+	PermEditInfo             Permission = "edit_info"
+	PermRemoveParticipants   Permission = "remove_participants"
+	PermDeleteOthersMessages Permission = "delete_others_messages"
+	PermPinMessages          Permission = "pin_messages"
+	PermManageRoles          Permission = "manage_roles"
+)
+
+// rolePermissions — матрица прав. Всё, чего здесь нет (писать, читать,
+// удалять свои сообщения), доступно любому участнику.
+var rolePermissions = map[Role][]Permission{
+	RoleOwner: {
+		PermDeleteChat,
+		PermAddParticipants,
+		PermEditInfo,
+		PermRemoveParticipants,
+		PermDeleteOthersMessages,
+		PermPinMessages,
+		PermManageRoles,
+	},
+	RoleAdmin: {
+		PermAddParticipants,
+		PermEditInfo,
+		PermRemoveParticipants,
+		PermDeleteOthersMessages,
+		PermPinMessages,
+	},
+	RoleMember: {},
+	RolePeer: {
+		PermDeleteChat,
+		PermPinMessages,
+	},
+}
+
+func (r Role) Can(p Permission) bool {
+	return slices.Contains(rolePermissions[r], p)
+}
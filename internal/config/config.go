@@ -12,17 +12,45 @@ type Config struct {
 	Env         string         `yaml:"env" json:"-"`
 	DatabaseDSN string         `yaml:"database_dsn" env:"DATABASE_URL" env-required:"true" json:"-"`
 	HTTPServer  HTTPServer     `yaml:"http_server" json:"-"`
+	Auth        AuthConfig     `yaml:"auth" json:"-"`
+	WS          WSConfig       `yaml:"ws" json:"-"`
 	App         AppConfig      `yaml:"app" json:"app"`
 	Messages    MessagesConfig `yaml:"messages" json:"messages"`
 	Uploads     UploadsConfig  `yaml:"uploads" json:"uploads"`
 }
 
+type AuthConfig struct {
+	SessionTTL time.Duration `yaml:"session_ttl" env-default:"720h"`
+	// DevSignIn разрешает вход по ?user_id= без пароля. Только для локальной разработки.
+	DevSignIn bool `yaml:"dev_sign_in" env-default:"false"`
+}
+
+const (
+	WSBackendMemory   = "memory"
+	WSBackendPostgres = "postgres"
+)
+
+type WSConfig struct {
+	// Backend: "memory" для одного инстанса, "postgres" (LISTEN/NOTIFY) для нескольких реплик
+	Backend string `yaml:"backend" env-default:"memory"`
+	// EventRetention: сколько хранить журнал событий чатов для replay
+	EventRetention time.Duration `yaml:"event_retention" env-default:"72h"`
+	// ReplayLimit: если клиент отстал больше чем на столько событий — resync_required
+	ReplayLimit int `yaml:"replay_limit" env-default:"1000"`
+}
+
 type AppConfig struct {
 	BaseURL string `yaml:"base_url" json:"base_url"`
 }
 
 type MessagesConfig struct {
 	MaxAttachments int `yaml:"max_attachments" json:"max_attachments"`
+	// EditWindowSec: сколько секунд после отправки можно редактировать сообщение, 0 — без ограничения.
+	EditWindowSec int `yaml:"edit_window_sec" env-default:"172800" json:"edit_window_sec"`
+}
+
+func (c MessagesConfig) EditWindow() time.Duration {
+	return time.Duration(c.EditWindowSec) * time.Second
 }
 
 type UploadsConfig struct {
@@ -0,0 +1,94 @@
+package matters
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Status поручения: closed — работа по нему завершена, чаты остаются.
+type Status string
+
+const (
+	StatusOpen   Status = "open"
+	StatusClosed Status = "closed"
+)
+
+func (s Status) IsValid() bool {
+	return s == StatusOpen || s == StatusClosed
+}
+
+type Matter struct {
+	ID        int64     `json:"id" db:"id"`
+	Title     string    `json:"title" db:"title"`
+	Status    Status    `json:"status" db:"status"`
+	CreatedBy *int64    `json:"created_by" db:"created_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Summary — то, что о поручении показывается в списке чатов.
+type Summary struct {
+	ID     int64  `json:"id"`
+	Title  string `json:"title"`
+	Status Status `json:"status"`
+}
+
+// SummaryRow — поля поручения из LEFT JOIN; у чата вне поручений все NULL.
+type SummaryRow struct {
+	ID     sql.NullInt64  `db:"id"`
+	Title  sql.NullString `db:"title"`
+	Status sql.NullString `db:"status"`
+}
+
+func (r SummaryRow) Summary() *Summary {
+	if !r.ID.Valid {
+		return nil
+	}
+	return &Summary{
+		ID:     r.ID.Int64,
+		Title:  r.Title.String,
+		Status: Status(r.Status.String),
+	}
+}
+
+const MaxTitleLen = 256
+
+func ValidateTitle(title string) error {
+	if strings.TrimSpace(title) == "" {
+		return ErrTitleIsRequired
+	}
+	if utf8.RuneCountInString(title) > MaxTitleLen {
+		return ErrTitleTooLong
+	}
+	return nil
+}
+
+type CreateMatterRequest struct {
+	Title string `json:"title"`
+}
+
+// UpdateMatterRequest: nil-поля не меняются.
+type UpdateMatterRequest struct {
+	Title  *string `json:"title"`
+	Status *Status `json:"status"`
+}
+
+type MatterResponse struct {
+	Matter Matter `json:"matter"`
+}
+
+type GetMattersResponse struct {
+	Matters []Matter `json:"matters"`
+}
+
+type Repo interface {
+	CreateMatter(ctx context.Context, createdBy int64, req CreateMatterRequest) (Matter, error)
+	GetMatter(ctx context.Context, id int64) (Matter, error)
+	// GetMatters: status == nil — все поручения
+	GetMatters(ctx context.Context, status *Status) ([]Matter, error)
+	UpdateMatter(ctx context.Context, id int64, req UpdateMatterRequest) (Matter, error)
+	DeleteMatter(ctx context.Context, id int64) error
+}
@@ -0,0 +1,13 @@
+package matters
+
+import "errors"
+
+var (
+	ErrMatterNotFound  = errors.New("matter not found")
+	ErrInvalidMatterID = errors.New("invalid matter_id")
+	ErrTitleIsRequired = errors.New("matter title is required")
+	ErrTitleTooLong    = errors.New("matter title is too long")
+	ErrInvalidStatus   = errors.New("matter status must be open or closed")
+	ErrMatterHasChats  = errors.New("matter still has chats")
+	ErrMatterClosed    = errors.New("matter is closed")
+)
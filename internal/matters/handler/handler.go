@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
+	"github.com/kgellert/hodatay-messenger/internal/matters"
+	"github.com/kgellert/hodatay-messenger/internal/transport/httpapi"
+	userhandlers "github.com/kgellert/hodatay-messenger/internal/users/handlers"
+)
+
+type Handler struct {
+	repo matters.Repo
+	log  *slog.Logger
+}
+
+func New(repo matters.Repo, log *slog.Logger) *Handler {
+	return &Handler{repo: repo, log: log}
+}
+
+func (h *Handler) CreateMatter() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.matters.create"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req matters.CreateMatterRequest
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			log.Error("failed to decode request", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		if err := matters.ValidateTitle(req.Title); err != nil {
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		m, err := h.repo.CreateMatter(r.Context(), userhandlers.UserID(r), req)
+		if err != nil {
+			log.Error("failed to create matter", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		log.Info("matter created", slog.Int64("matter_id", m.ID))
+
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, matters.MatterResponse{Matter: m})
+	}
+}
+
+// GetMatters: ?status=open|closed, без него — все.
+func (h *Handler) GetMatters() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.matters.get.list"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var status *matters.Status
+		if s := r.URL.Query().Get("status"); s != "" {
+			st := matters.Status(s)
+			if !st.IsValid() {
+				httpapi.WriteError(w, r, matters.ErrInvalidStatus)
+				return
+			}
+			status = &st
+		}
+
+		list, err := h.repo.GetMatters(r.Context(), status)
+		if err != nil {
+			log.Error("failed to get matters", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, matters.GetMattersResponse{Matters: list})
+	}
+}
+
+func (h *Handler) GetMatter() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.matters.get"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		id, ok := MatterID(r)
+		if !ok {
+			httpapi.WriteError(w, r, matters.ErrInvalidMatterID)
+			return
+		}
+
+		m, err := h.repo.GetMatter(r.Context(), id)
+		if err != nil {
+			log.Error("failed to get matter", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, matters.MatterResponse{Matter: m})
+	}
+}
+
+// UpdateMatter меняет название и статус. Поручениями управляют админы:
+// маршрут стоит за RequireAdmin, как и удаление.
+func (h *Handler) UpdateMatter() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.matters.update"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		id, ok := MatterID(r)
+		if !ok {
+			httpapi.WriteError(w, r, matters.ErrInvalidMatterID)
+			return
+		}
+
+		var req matters.UpdateMatterRequest
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			log.Error("failed to decode request", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		if req.Title != nil {
+			if err := matters.ValidateTitle(*req.Title); err != nil {
+				httpapi.WriteError(w, r, err)
+				return
+			}
+		}
+		if req.Status != nil && !req.Status.IsValid() {
+			httpapi.WriteError(w, r, matters.ErrInvalidStatus)
+			return
+		}
+
+		m, err := h.repo.UpdateMatter(r.Context(), id, req)
+		if err != nil {
+			log.Error("failed to update matter", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, matters.MatterResponse{Matter: m})
+	}
+}
+
+func (h *Handler) DeleteMatter() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.matters.delete"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		id, ok := MatterID(r)
+		if !ok {
+			httpapi.WriteError(w, r, matters.ErrInvalidMatterID)
+			return
+		}
+
+		if err := h.repo.DeleteMatter(r.Context(), id); err != nil {
+			log.Error("failed to delete matter", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// MatterID достаёт {matterId} из пути.
+func MatterID(r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "matterId"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,133 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jmoiron/sqlx"
+	"github.com/kgellert/hodatay-messenger/internal/matters"
+)
+
+const foreignKeyViolation = "23503"
+
+const matterColumns = `id, title, status, created_by, created_at, updated_at`
+
+type Repo struct {
+	db *sqlx.DB
+}
+
+func New(db *sqlx.DB) *Repo {
+	return &Repo{db: db}
+}
+
+func (r *Repo) CreateMatter(ctx context.Context, createdBy int64, req matters.CreateMatterRequest) (matters.Matter, error) {
+	const op = "storage.postgres.CreateMatter"
+
+	var m matters.Matter
+	err := r.db.GetContext(
+		ctx,
+		&m,
+		`
+		INSERT INTO matters (title, created_by)
+		VALUES ($1, $2)
+		RETURNING `+matterColumns,
+		req.Title, createdBy,
+	)
+	if err != nil {
+		return matters.Matter{}, fmt.Errorf("%s: insert: %w", op, err)
+	}
+
+	return m, nil
+}
+
+func (r *Repo) GetMatter(ctx context.Context, id int64) (matters.Matter, error) {
+	const op = "storage.postgres.GetMatter"
+
+	var m matters.Matter
+	err := r.db.GetContext(ctx, &m, `SELECT `+matterColumns+` FROM matters WHERE id = $1`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return matters.Matter{}, matters.ErrMatterNotFound
+	}
+	if err != nil {
+		return matters.Matter{}, fmt.Errorf("%s: select: %w", op, err)
+	}
+
+	return m, nil
+}
+
+// GetMatters возвращает поручения от новых к старым.
+func (r *Repo) GetMatters(ctx context.Context, status *matters.Status) ([]matters.Matter, error) {
+	const op = "storage.postgres.GetMatters"
+
+	list := []matters.Matter{}
+	err := r.db.SelectContext(
+		ctx,
+		&list,
+		`
+		SELECT `+matterColumns+`
+		FROM matters
+		WHERE $1::text IS NULL OR status = $1
+		ORDER BY created_at DESC, id DESC
+		`,
+		status,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: select: %w", op, err)
+	}
+
+	return list, nil
+}
+
+func (r *Repo) UpdateMatter(ctx context.Context, id int64, req matters.UpdateMatterRequest) (matters.Matter, error) {
+	const op = "storage.postgres.UpdateMatter"
+
+	var m matters.Matter
+	err := r.db.GetContext(
+		ctx,
+		&m,
+		`
+		UPDATE matters
+		SET title      = COALESCE($1, title),
+		    status     = COALESCE($2, status),
+		    updated_at = now()
+		WHERE id = $3
+		RETURNING `+matterColumns,
+		req.Title, req.Status, id,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return matters.Matter{}, matters.ErrMatterNotFound
+	}
+	if err != nil {
+		return matters.Matter{}, fmt.Errorf("%s: update: %w", op, err)
+	}
+
+	return m, nil
+}
+
+// DeleteMatter удаляет поручение без чатов; чаты сначала надо удалить,
+// перевести в другое поручение или вывести из поручения (PATCH /chats/{chatId}).
+func (r *Repo) DeleteMatter(ctx context.Context, id int64) error {
+	const op = "storage.postgres.DeleteMatter"
+
+	res, err := r.db.ExecContext(ctx, `DELETE FROM matters WHERE id = $1`, id)
+	if isForeignKeyViolation(err) {
+		return matters.ErrMatterHasChats
+	}
+	if err != nil {
+		return fmt.Errorf("%s: delete: %w", op, err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return matters.ErrMatterNotFound
+	}
+
+	return nil
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation
+}
@@ -3,17 +3,55 @@ package messages
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	uploadsdomain "github.com/kgellert/hodatay-messenger/internal/uploads/domain"
 )
 
 type Repo interface {
-	SendMessage(ctx context.Context, chatID, userID int64, text string, attachments []CreateMessageAttachment, replyToMessageID *int64) (*Message, error)
-	GetMessages(ctx context.Context, chatID int64, limit, offset int) ([]Message, error)
+	// SendMessage возвращает created=false, если сообщение с таким clientMsgID
+	// уже было отправлено: тогда это оно, а не новое
+	SendMessage(ctx context.Context, chatID, userID int64, text string, attachments []CreateMessageAttachment, replyToMessageID *int64, clientMsgID *string) (msg *Message, created bool, err error)
+	GetMessages(ctx context.Context, chatID, userID int64, params GetMessagesParams) (*MessagesPage, error)
 	SetLastReadMessage(ctx context.Context, chatID, userID, lastReadMessageID int64) (int64, error)
-	DeleteMessage(ctx context.Context, chatID, messageID int64) error
-	DeleteMessages(ctx context.Context, chatID int64, messageIDs []int64) ([]int64, error)
+	// canDeleteOthers разрешает удалять у всех чужие сообщения (админам чата).
+	// unpinned — удалённые у всех сообщения, с которых при этом снят закреп
+	DeleteMessage(ctx context.Context, chatID, userID, messageID int64, scope DeleteScope, canDeleteOthers bool) (unpinned bool, err error)
+	DeleteMessages(ctx context.Context, chatID, userID int64, messageIDs []int64, scope DeleteScope, canDeleteOthers bool) (deleted, unpinned []int64, err error)
+	EditMessage(ctx context.Context, chatID, messageID, userID int64, text string, editWindow time.Duration) (*Message, error)
+	GetMessageEdits(ctx context.Context, chatID, messageID int64) ([]MessageEdit, error)
+	AddReaction(ctx context.Context, chatID, messageID, userID int64, emoji string) (bool, error)
+	RemoveReaction(ctx context.Context, chatID, messageID, userID int64, emoji string) (bool, error)
+	SearchMessages(ctx context.Context, userID int64, params SearchMessagesParams) (*SearchMessagesPage, error)
+	// PinMessage возвращает pinned=false, если сообщение уже было закреплено
+	PinMessage(ctx context.Context, chatID, messageID, userID int64) (pin *Pin, pinned bool, err error)
+	UnpinMessage(ctx context.Context, chatID, messageID int64) (bool, error)
+	GetPins(ctx context.Context, chatID, userID int64) ([]Pin, error)
+	ForwardMessages(ctx context.Context, userID int64, req ForwardMessagesRequest) ([]ForwardedMessages, error)
+	ReactionsReader
+	SystemMessageWriter
+	PinsReader
+}
+
+// SystemMessageWriter записывает в ленту чата системное сообщение от имени actorID.
+type SystemMessageWriter interface {
+	CreateSystemMessage(ctx context.Context, chatID, actorID int64, payload SystemPayload) (*Message, error)
+}
+
+// PinsReader отдаёт последнее закреплённое сообщение чата, не скрытое
+// userID у себя, или nil.
+type PinsReader interface {
+	GetLatestPin(ctx context.Context, chatID, userID int64) (*Pin, error)
+}
+
+// ReactionsReader отдаёт агрегированные реакции; userID нужен для ReactedByMe.
+type ReactionsReader interface {
+	GetReactions(ctx context.Context, userID int64, messageIDs []int64) (map[int64][]Reaction, error)
 }
 
 func NewMessageFromRow(row MessageRow, attachments []uploadsdomain.AttachmentRow, replyAttachments []uploadsdomain.AttachmentRow) Message {
@@ -31,22 +69,62 @@ func NewMessageFromRow(row MessageRow, attachments []uploadsdomain.AttachmentRow
 
 	var rm *Message
 	if row.ReplyTo.ID.Valid {
-		rm = &Message{
-			ID:           row.ReplyTo.ID.Int64,
-			SenderUserID: row.ReplyTo.SenderUserID.Int64,
-			Text:         row.ReplyTo.Text.String,
-			CreatedAt:    row.ReplyTo.CreatedAt.Time,
-			Attachments:  rAtts,
-		}
+		rm = NewReplyFromRow(row.ReplyTo)
+		rm.Attachments = rAtts
+	}
+
+	var editedAt *time.Time
+	if row.EditedAt.Valid {
+		editedAt = &row.EditedAt.Time
+	}
+
+	var deletedAt *time.Time
+	if row.DeletedAt.Valid {
+		deletedAt = &row.DeletedAt.Time
+	}
+
+	var deletedBy *int64
+	if row.DeletedBy.Valid {
+		deletedBy = &row.DeletedBy.Int64
+	}
+
+	kind := KindUser
+	if row.Kind != "" {
+		kind = row.Kind
 	}
 
 	return Message{
 		ID:           row.ID,
 		SenderUserID: row.SenderUserID,
+		Kind:         kind,
+		System:       row.Payload,
 		Text:         row.Text,
 		CreatedAt:    row.CreatedAt,
+		EditedAt:     editedAt,
+		DeletedAt:    deletedAt,
+		DeletedBy:    deletedBy,
 		Attachments:  atts,
 		ReplyTo:      rm,
+		Reactions:    []Reaction{},
+
+		ForwardedFrom: row.ForwardedFrom.ForwardedFrom(),
+	}
+}
+
+// NewReplyFromRow собирает цитату без вложений; их добавляет вызывающий.
+func NewReplyFromRow(row MessageRowNullable) *Message {
+	var deletedAt *time.Time
+	if row.DeletedAt.Valid {
+		deletedAt = &row.DeletedAt.Time
+	}
+
+	return &Message{
+		ID:           row.ID.Int64,
+		SenderUserID: row.SenderUserID.Int64,
+		Text:         row.Text.String,
+		CreatedAt:    row.CreatedAt.Time,
+		DeletedAt:    deletedAt,
+		Attachments:  []uploadsdomain.Attachment{},
 	}
 }
 
@@ -55,27 +133,281 @@ func NewMessageFromChatRow(row ChatLastMessageRow) *MessageRow {
 		return nil
 	}
 	return &MessageRow{
-		row.ID.Int64,
-		row.SenderUserID.Int64,
-		row.Text.String,
-		row.CreatedAt.Time,
-		row.ReplyTo,
-		row.Attachment,
-		row.ReplyToAttachment,
+		ID:                row.ID.Int64,
+		SenderUserID:      row.SenderUserID.Int64,
+		Kind:              MessageKind(row.Kind.String),
+		Payload:           row.Payload,
+		Text:              row.Text.String,
+		CreatedAt:         row.CreatedAt.Time,
+		EditedAt:          row.EditedAt,
+		DeletedAt:         row.DeletedAt,
+		ReplyTo:           row.ReplyTo,
+		ForwardedFrom:     row.ForwardedFrom,
+		Attachment:        row.Attachment,
+		ReplyToAttachment: row.ReplyToAttachment,
 	}
 }
 
+// Message с DeletedAt != nil — заглушка удалённого у всех сообщения:
+// текст пустой, вложений нет.
 type Message struct {
 	ID           int64                      `json:"id" db:"id"`
 	SenderUserID int64                      `json:"user_id" db:"sender_user_id"`
+	Kind         MessageKind                `json:"kind" db:"kind"`
+	System       *SystemPayload             `json:"system,omitempty" db:"payload"`
 	Text         string                     `json:"text" db:"text"`
 	CreatedAt    time.Time                  `json:"created_at" db:"created_at"`
+	EditedAt     *time.Time                 `json:"edited_at" db:"edited_at"`
+	DeletedAt    *time.Time                 `json:"deleted_at,omitempty" db:"deleted_at"`
+	DeletedBy    *int64                     `json:"deleted_by,omitempty" db:"deleted_by"`
 	Attachments  []uploadsdomain.Attachment `json:"attachments" db:"attachments"`
 	ReplyTo      *Message                   `json:"reply_to" db:"reply_to"`
+	Reactions    []Reaction                 `json:"reactions" db:"-"`
+
+	ForwardedFrom *ForwardedFrom `json:"forwarded_from,omitempty" db:"-"`
+}
+
+// Shared возвращает копию сообщения без полей, посчитанных для конкретного
+// пользователя, — такую можно рассылать всем участникам чата.
+func (m Message) Shared() Message {
+	if len(m.Reactions) > 0 {
+		reactions := make([]Reaction, len(m.Reactions))
+		for i, r := range m.Reactions {
+			r.ReactedByMe = nil
+			reactions[i] = r
+		}
+		m.Reactions = reactions
+	}
+	if m.ForwardedFrom != nil {
+		f := *m.ForwardedFrom
+		f.ChatID = nil
+		m.ForwardedFrom = &f
+	}
+	return m
+}
+
+// CheckResent проверяет сообщение, найденное по client_msg_id при повторной
+// отправке: удалённое у всех нельзя вернуть как успешно отправленное.
+func CheckResent(m Message) error {
+	if m.DeletedAt != nil {
+		return ErrResentMessageDeleted
+	}
+	return nil
+}
+
+// ForwardedFrom — откуда переслано сообщение. При пересылке пересланного
+// указывается первоисточник. Поля обнуляются, если исходные пользователь,
+// чат или сообщение удалены физически. ChatID заполняется, только если
+// смотрящий состоит в исходном чате (см. RevealChat): участники чата, куда
+// переслали, могут в нём не состоять.
+type ForwardedFrom struct {
+	UserID    *int64 `json:"user_id"`
+	ChatID    *int64 `json:"chat_id"`
+	MessageID *int64 `json:"message_id"`
+
+	sourceChatID *int64
+}
+
+// SourceChatID — исходный чат, даже если смотрящему он не виден.
+func (f *ForwardedFrom) SourceChatID() (int64, bool) {
+	if f.sourceChatID == nil {
+		return 0, false
+	}
+	return *f.sourceChatID, true
+}
+
+// RevealChat открывает ChatID: вызывается, когда смотрящий состоит в исходном чате.
+func (f *ForwardedFrom) RevealChat() {
+	f.ChatID = f.sourceChatID
+}
+
+type ForwardedFromRow struct {
+	UserID    sql.NullInt64 `db:"user_id"`
+	ChatID    sql.NullInt64 `db:"chat_id"`
+	MessageID sql.NullInt64 `db:"message_id"`
+}
+
+// ForwardedFrom возвращает nil, если сообщение не пересланное. ChatID
+// остаётся скрытым до RevealChat.
+func (r ForwardedFromRow) ForwardedFrom() *ForwardedFrom {
+	if !r.UserID.Valid && !r.ChatID.Valid && !r.MessageID.Valid {
+		return nil
+	}
+
+	f := &ForwardedFrom{}
+	if r.UserID.Valid {
+		f.UserID = &r.UserID.Int64
+	}
+	if r.ChatID.Valid {
+		f.sourceChatID = &r.ChatID.Int64
+	}
+	if r.MessageID.Valid {
+		f.MessageID = &r.MessageID.Int64
+	}
+	return f
+}
+
+const (
+	MaxForwardMessages = 100
+	MaxForwardTargets  = 10
+)
+
+// ForwardMessagesRequest: сообщения FromChatID копируются в каждый из
+// ToChatIDs в исходном порядке. Системные и удалённые пропускаются.
+type ForwardMessagesRequest struct {
+	FromChatID int64   `json:"from_chat_id"`
+	MessageIDs []int64 `json:"message_ids"`
+	ToChatIDs  []int64 `json:"to_chat_ids"`
+}
+
+func (r ForwardMessagesRequest) Validate() error {
+	if r.FromChatID <= 0 || len(r.MessageIDs) == 0 || len(r.ToChatIDs) == 0 {
+		return ErrInvalidForward
+	}
+	if len(r.MessageIDs) > MaxForwardMessages || len(r.ToChatIDs) > MaxForwardTargets {
+		return ErrTooManyForwarded
+	}
+	for _, id := range r.MessageIDs {
+		if id <= 0 {
+			return ErrInvalidForward
+		}
+	}
+	for _, id := range r.ToChatIDs {
+		if id <= 0 {
+			return ErrInvalidForward
+		}
+	}
+	return nil
+}
+
+type ForwardedMessages struct {
+	ChatID   int64     `json:"chat_id"`
+	Messages []Message `json:"messages"`
+}
+
+type ForwardMessagesResponse struct {
+	Chats []ForwardedMessages `json:"chats"`
+}
+
+// MessageKind: system — запись о событии в чате, её текст пустой,
+// а что случилось — в Message.System. Клиент сам собирает из него
+// локализованную строку ("Иван добавил Романа").
+type MessageKind string
+
+const (
+	KindUser   MessageKind = "user"
+	KindSystem MessageKind = "system"
+)
+
+type SystemEvent string
+
+const (
+	SystemChatCreated   SystemEvent = "chat_created"
+	SystemMembersAdded  SystemEvent = "members_added"
+	SystemMemberRemoved SystemEvent = "member_removed"
+	SystemMemberLeft    SystemEvent = "member_left"
+	SystemTitleChanged  SystemEvent = "title_changed"
+	SystemMessagePinned SystemEvent = "message_pinned"
+)
+
+// SystemPayload — содержимое системного сообщения. Кто совершил действие,
+// видно по SenderUserID сообщения. Заполнены только поля, относящиеся к Event:
+//   - chat_created: UserIDs — участники, кроме создателя
+//   - members_added, member_removed, member_left: UserIDs
+//   - title_changed: Title — новое название
+//   - message_pinned: MessageID
+type SystemPayload struct {
+	Event     SystemEvent `json:"event"`
+	UserIDs   []int64     `json:"user_ids,omitempty"`
+	Title     string      `json:"title,omitempty"`
+	MessageID int64       `json:"message_id,omitempty"`
+}
+
+func (p SystemPayload) Value() (driver.Value, error) {
+	return json.Marshal(p)
+}
+
+func (p *SystemPayload) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, p)
+	case string:
+		return json.Unmarshal([]byte(v), p)
+	}
+	return fmt.Errorf("system payload: unsupported type %T", src)
+}
+
+type Reaction struct {
+	Emoji string `json:"emoji" db:"emoji"`
+	Count int64  `json:"count" db:"count"`
+	// ReactedByMe считается для того, кто запросил; в WS-событиях его нет,
+	// см. Message.Shared
+	ReactedByMe *bool `json:"reacted_by_me,omitempty" db:"reacted_by_me"`
+}
+
+const maxEmojiBytes = 32
+
+// IsValidEmoji — грубая проверка: непустая короткая строка без пробелов.
+// Конкретный набор эмодзи решает клиент.
+func IsValidEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > maxEmojiBytes || !utf8.ValidString(emoji) {
+		return false
+	}
+
+	for _, r := range emoji {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Pin — закреплённое сообщение. PinnedBy == nil, если закрепивший удалён.
+// Закреп снимается вместе с удалением сообщения у всех.
+type Pin struct {
+	Message  Message   `json:"message"`
+	PinnedBy *int64    `json:"pinned_by"`
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+type PinResponse struct {
+	Pin Pin `json:"pin"`
+}
+
+// GetPinsResponse: от последнего закреплённого к первому.
+type GetPinsResponse struct {
+	Pins []Pin `json:"pins"`
+}
+
+type ReactionsResponse struct {
+	Reactions []Reaction `json:"reactions"`
 }
 
 type DeleteMessagesRequestResponse struct {
 	MessageIDs []int64 `json:"message_ids"`
+	// Scope учитывается только в запросе, по умолчанию everyone
+	Scope DeleteScope `json:"scope,omitempty"`
+}
+
+// DeleteScope: "me" скрывает сообщения только у вызывающего,
+// "everyone" превращает их в заглушку для всех участников.
+type DeleteScope string
+
+const (
+	DeleteForMe       DeleteScope = "me"
+	DeleteForEveryone DeleteScope = "everyone"
+)
+
+// ParseDeleteScope: пустая строка — everyone, как было до появления scope.
+func ParseDeleteScope(s string) (DeleteScope, error) {
+	switch DeleteScope(s) {
+	case "", DeleteForEveryone:
+		return DeleteForEveryone, nil
+	case DeleteForMe:
+		return DeleteForMe, nil
+	}
+	return "", ErrInvalidDeleteScope
 }
 
 type SetLastReadMessageRequest struct {
@@ -86,6 +418,25 @@ type CreateMessageRequest struct {
 	Text             string                    `json:"text"`
 	Attachments      []CreateMessageAttachment `json:"attachments"`
 	ReplyToMessageID *int64                    `json:"reply_to_message_id"`
+	// ClientMsgID — ключ идемпотентности (или заголовок Idempotency-Key):
+	// повтор с тем же ключом вернёт уже созданное сообщение
+	ClientMsgID string `json:"client_msg_id,omitempty"`
+}
+
+const MaxClientMsgIDLen = 128
+
+type EditMessageRequest struct {
+	Text string `json:"text"`
+}
+
+type MessageEdit struct {
+	ID       int64     `json:"id" db:"id"`
+	Text     string    `json:"text" db:"text"`
+	EditedAt time.Time `json:"edited_at" db:"edited_at"`
+}
+
+type GetMessageEditsResponse struct {
+	Edits []MessageEdit `json:"edits"`
 }
 
 type CreateMessageAttachment struct {
@@ -96,8 +447,54 @@ type CreateMessageResponse struct {
 	Message `json:"message"`
 }
 
+// GetMessagesParams: не больше одного курсора. Без курсора — последние Limit сообщений.
+// AroundID возвращает окно вокруг сообщения, включая его самого.
+type GetMessagesParams struct {
+	Limit    int
+	BeforeID *int64
+	AfterID  *int64
+	AroundID *int64
+}
+
+// MessagesPage: сообщения по возрастанию (created_at, id) и признаки того,
+// что за краями страницы есть ещё сообщения.
+type MessagesPage struct {
+	Messages      []Message
+	HasMoreBefore bool
+	HasMoreAfter  bool
+}
+
+// SearchMessagesParams: ChatID == nil — поиск по всем чатам пользователя.
+// Результаты идут от новых к старым, BeforeID продолжает выдачу.
+type SearchMessagesParams struct {
+	Query    string
+	ChatID   *int64
+	Limit    int
+	BeforeID *int64
+}
+
+// SearchHit: Snippet — фрагмент текста (или имени вложения) с совпадениями,
+// обёрнутыми в <mark></mark>. Остальной текст HTML-экранирован.
+type SearchHit struct {
+	ChatID  int64   `json:"chat_id"`
+	Message Message `json:"message"`
+	Snippet string  `json:"snippet"`
+}
+
+type SearchMessagesPage struct {
+	Hits    []SearchHit
+	HasMore bool
+}
+
+type SearchMessagesResponse struct {
+	Results []SearchHit `json:"results"`
+	HasMore bool        `json:"has_more"`
+}
+
 type GetMessagesResponse struct {
-	Messages []Message `json:"messages"`
+	Messages      []Message `json:"messages"`
+	HasMoreBefore bool      `json:"has_more_before"`
+	HasMoreAfter  bool      `json:"has_more_after"`
 }
 
 type MessageRowNullable struct {
@@ -105,27 +502,39 @@ type MessageRowNullable struct {
 	SenderUserID sql.NullInt64  `db:"sender_user_id"`
 	Text         sql.NullString `db:"text"`
 	CreatedAt    sql.NullTime   `db:"created_at"`
+	DeletedAt    sql.NullTime   `db:"deleted_at"`
 }
 
 type ChatLastMessageRow struct {
 	ID           sql.NullInt64  `db:"id"`
 	SenderUserID sql.NullInt64  `db:"sender_user_id"`
+	Kind         sql.NullString `db:"kind"`
+	Payload      *SystemPayload `db:"payload"`
 	Text         sql.NullString `db:"text"`
 	CreatedAt    sql.NullTime   `db:"created_at"`
+	EditedAt     sql.NullTime   `db:"edited_at"`
+	DeletedAt    sql.NullTime   `db:"deleted_at"`
 
-	ReplyTo MessageRowNullable `db:"reply_to"`
+	ReplyTo       MessageRowNullable `db:"reply_to"`
+	ForwardedFrom ForwardedFromRow   `db:"forwarded_from"`
 
 	Attachment        uploadsdomain.AttachmentRow `db:"attachment"`
 	ReplyToAttachment uploadsdomain.AttachmentRow `db:"reply_to.attachment"`
 }
 
 type MessageRow struct {
-	ID           int64     `db:"id"`
-	SenderUserID int64     `db:"sender_user_id"`
-	Text         string    `db:"text"`
-	CreatedAt    time.Time `db:"created_at"`
+	ID           int64          `db:"id"`
+	SenderUserID int64          `db:"sender_user_id"`
+	Kind         MessageKind    `db:"kind"`
+	Payload      *SystemPayload `db:"payload"`
+	Text         string         `db:"text"`
+	CreatedAt    time.Time      `db:"created_at"`
+	EditedAt     sql.NullTime   `db:"edited_at"`
+	DeletedAt    sql.NullTime   `db:"deleted_at"`
+	DeletedBy    sql.NullInt64  `db:"deleted_by"`
 
-	ReplyTo MessageRowNullable `db:"reply_to"`
+	ReplyTo       MessageRowNullable `db:"reply_to"`
+	ForwardedFrom ForwardedFromRow   `db:"forwarded_from"`
 
 	Attachment        uploadsdomain.AttachmentRow `db:"attachment"`
 	ReplyToAttachment uploadsdomain.AttachmentRow `db:"reply_to.attachment"`
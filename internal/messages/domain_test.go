@@ -0,0 +1,55 @@
+package messages
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckResent(t *testing.T) {
+	deletedAt := time.Now()
+
+	tests := []struct {
+		name    string
+		msg     Message
+		wantErr error
+	}{
+		{name: "retry returns the sent message", msg: Message{ID: 1}},
+		{name: "retry after delete for everyone", msg: Message{ID: 1, DeletedAt: &deletedAt}, wantErr: ErrResentMessageDeleted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckResent(tt.msg); !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckResent() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestForwardedFromChatVisibility(t *testing.T) {
+	row := ForwardedFromRow{
+		UserID:    sql.NullInt64{Int64: 7, Valid: true},
+		ChatID:    sql.NullInt64{Int64: 42, Valid: true},
+		MessageID: sql.NullInt64{Int64: 100, Valid: true},
+	}
+
+	f := row.ForwardedFrom()
+	if f.ChatID != nil {
+		t.Fatalf("ChatID = %d before RevealChat, want nil", *f.ChatID)
+	}
+
+	f.RevealChat()
+	if f.ChatID == nil || *f.ChatID != 42 {
+		t.Fatalf("ChatID = %v after RevealChat, want 42", f.ChatID)
+	}
+
+	msg := Message{ID: 1, ForwardedFrom: f}
+	shared := msg.Shared()
+	if shared.ForwardedFrom.ChatID != nil {
+		t.Errorf("Shared().ForwardedFrom.ChatID = %d, want nil", *shared.ForwardedFrom.ChatID)
+	}
+	if msg.ForwardedFrom.ChatID == nil {
+		t.Error("Shared() modified the original message")
+	}
+}
@@ -10,6 +10,19 @@ var (
 	ErrMessageIsNil                = errors.New("message is nil")
 	ErrMessageIsNotExist           = errors.New("message is not exist")
 	ErrMessagesIsNotExist          = errors.New("messages is not exist")
-	ErrInvalidPage                 = errors.New("invalid page")
+	ErrInvalidCursor               = errors.New("invalid before_id, after_id or around_id")
+	ErrConflictingCursors          = errors.New("only one of before_id, after_id, around_id is allowed")
 	ErrInvalidLimit                = errors.New("invalid limit")
+	ErrInvalidMessageID            = errors.New("invalid message_id")
+	ErrNotMessageSender            = errors.New("only the sender can edit the message")
+	ErrEditWindowExpired           = errors.New("message edit window has expired")
+	ErrInvalidEmoji                = errors.New("invalid emoji")
+	ErrEmptySearchQuery            = errors.New("search query is required")
+	ErrInvalidClientMsgID          = errors.New("invalid client_msg_id or Idempotency-Key")
+	ErrResentMessageDeleted        = errors.New("message with this client_msg_id was sent and then deleted")
+	ErrInvalidDeleteScope          = errors.New("scope must be me or everyone")
+	ErrDeleteNotAllowed            = errors.New("only the sender or a chat admin can delete the message for everyone")
+	ErrSystemMessagePin            = errors.New("system messages cannot be pinned")
+	ErrInvalidForward              = errors.New("from_chat_id, message_ids and to_chat_ids are required")
+	ErrTooManyForwarded            = errors.New("too many messages or target chats to forward")
 )
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
+	"github.com/kgellert/hodatay-messenger/internal/messages"
+	"github.com/kgellert/hodatay-messenger/internal/transport/httpapi"
+	userhandlers "github.com/kgellert/hodatay-messenger/internal/users/handlers"
+	"github.com/kgellert/hodatay-messenger/internal/ws"
+)
+
+// ForwardMessages: POST /messages/forward. Пользователь должен состоять и в
+// исходном чате, и во всех целевых; в каждый целевой уходит message.new.
+func (h *Handler) ForwardMessages() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.messages.forward"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req messages.ForwardMessagesRequest
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			log.Error("decode request error", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		if err := req.Validate(); err != nil {
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		userID := userhandlers.UserID(r)
+
+		if err := h.chatsPolicy.CheckMember(r.Context(), req.FromChatID, userID); err != nil {
+			httpapi.WriteError(w, r, err)
+			return
+		}
+		if err := h.chatsPolicy.CheckMemberAll(r.Context(), userID, req.ToChatIDs); err != nil {
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		forwarded, err := h.messagesRepo.ForwardMessages(r.Context(), userID, req)
+		if err != nil {
+			log.Error("failed to forward messages", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, messages.ForwardMessagesResponse{
+			Chats: forwarded,
+		})
+
+		for _, f := range forwarded {
+			for _, msg := range f.Messages {
+				h.broadcast(r.Context(), log, f.ChatID, ws.MessageNew, ws.MessageNewPayload{Message: msg.Shared()})
+			}
+		}
+	}
+}
@@ -1,45 +1,58 @@
 package handler
 
 import (
-	"encoding/json"
+	"context"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
+	"github.com/kgellert/hodatay-messenger/internal/chats"
+	"github.com/kgellert/hodatay-messenger/internal/chats/policy"
+	"github.com/kgellert/hodatay-messenger/internal/config"
 	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
 	"github.com/kgellert/hodatay-messenger/internal/messages"
 	"github.com/kgellert/hodatay-messenger/internal/transport/httpapi"
 	uploads "github.com/kgellert/hodatay-messenger/internal/uploads/domain"
 	userhandlers "github.com/kgellert/hodatay-messenger/internal/users/handlers"
 	"github.com/kgellert/hodatay-messenger/internal/ws"
-	"github.com/kgellert/hodatay-messenger/internal/ws/hub"
 )
 
 type Handler struct {
 	messagesRepo   messages.Repo
 	uploadsService uploads.Service
-	hub            *hub.Hub
+	events         ws.Publisher
+	chatsPolicy    *policy.Policy
+	cfg            config.MessagesConfig
 	log            *slog.Logger
 }
 
 func New(
 	messagesRepo messages.Repo,
 	uploadsService uploads.Service,
-	h *hub.Hub,
+	events ws.Publisher,
+	chatsPolicy *policy.Policy,
+	cfg config.MessagesConfig,
 	log *slog.Logger,
 ) *Handler {
-	return &Handler{messagesRepo: messagesRepo, uploadsService: uploadsService, hub: h, log: log}
+	return &Handler{
+		messagesRepo:   messagesRepo,
+		uploadsService: uploadsService,
+		events:         events,
+		chatsPolicy:    chatsPolicy,
+		cfg:            cfg,
+		log:            log,
+	}
 }
 
 func (h *Handler) GetMessages() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.messages.GetMessages"
 		const defaultLimit = 20
-		const defaultPage = 0
 		const maxLimit = 100
 
 		log := h.log.With(
@@ -65,18 +78,34 @@ func (h *Handler) GetMessages() http.HandlerFunc {
 			}
 		}
 
-		p := defaultPage
-		if pStr := r.URL.Query().Get("page"); pStr != "" {
-			if parsed, err := strconv.Atoi(pStr); err == nil && parsed >= 0 {
-				p = parsed
-			} else {
-				httpapi.WriteError(w, r, messages.ErrInvalidPage)
+		params := messages.GetMessagesParams{Limit: l}
+		cursors := 0
+		for name, dst := range map[string]**int64{
+			"before_id": &params.BeforeID,
+			"after_id":  &params.AfterID,
+			"around_id": &params.AroundID,
+		} {
+			raw := r.URL.Query().Get(name)
+			if raw == "" {
+				continue
+			}
+
+			id, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || id <= 0 {
+				httpapi.WriteError(w, r, messages.ErrInvalidCursor)
 				return
 			}
+
+			*dst = &id
+			cursors++
 		}
 
-		offset := p * l
-		msgs, err := h.messagesRepo.GetMessages(r.Context(), chatID, l, offset)
+		if cursors > 1 {
+			httpapi.WriteError(w, r, messages.ErrConflictingCursors)
+			return
+		}
+
+		page, err := h.messagesRepo.GetMessages(r.Context(), chatID, userhandlers.UserID(r), params)
 		if err != nil {
 			log.Error("failed to get messages", sl.Err(err))
 			httpapi.WriteError(w, r, err)
@@ -84,7 +113,9 @@ func (h *Handler) GetMessages() http.HandlerFunc {
 		}
 
 		render.JSON(w, r, messages.GetMessagesResponse{
-			Messages: msgs,
+			Messages:      page.Messages,
+			HasMoreBefore: page.HasMoreBefore,
+			HasMoreAfter:  page.HasMoreAfter,
 		})
 	}
 }
@@ -113,52 +144,72 @@ func (h *Handler) SendMessage() http.HandlerFunc {
 			return
 		}
 
-		if strings.TrimSpace(req.Text) == "" && len(req.Attachments) == 0 {
-			httpapi.WriteError(w, r, messages.ErrTextOrAttachmentsIsRequired)
-			return
+		if key := r.Header.Get("Idempotency-Key"); key != "" {
+			if req.ClientMsgID != "" && req.ClientMsgID != key {
+				httpapi.WriteError(w, r, messages.ErrInvalidClientMsgID)
+				return
+			}
+			req.ClientMsgID = key
 		}
 
 		userID := userhandlers.UserID(r)
 
-		msg, err := h.messagesRepo.SendMessage(
-			r.Context(),
-			chatID,
-			userID,
-			req.Text,
-			req.Attachments,
-			req.ReplyToMessageID,
-		)
-
+		msg, err := h.Send(r.Context(), log, chatID, userID, req)
 		if err != nil {
 			log.Error("failed to send message", sl.Err(err))
 			httpapi.WriteError(w, r, err)
 			return
 		}
 
-		if msg == nil {
-			log.Error("failed to send message", sl.Err(messages.ErrMessageIsNil))
-			httpapi.WriteError(w, r, err)
-			return
-		}
-
 		render.JSON(w, r, messages.CreateMessageResponse{
 			Message: *msg,
 		})
+	}
+}
 
-		evt, err := ws.NewEvent(chatID, ws.MessageNew, ws.MessageNewPayload{Message: *msg})
-		if err != nil {
-			log.Error("failed to build ws event", sl.Err(err))
-			return
-		}
+// Send — общий путь отправки сообщения для HTTP и WS: проверка запроса,
+// запись в БД и рассылка message.new. Повтор с тем же client_msg_id
+// возвращает исходное сообщение без второго message.new.
+func (h *Handler) Send(
+	ctx context.Context,
+	log *slog.Logger,
+	chatID, userID int64,
+	req messages.CreateMessageRequest,
+) (*messages.Message, error) {
+	if strings.TrimSpace(req.Text) == "" && len(req.Attachments) == 0 {
+		return nil, messages.ErrTextOrAttachmentsIsRequired
+	}
 
-		payload, err := json.Marshal(evt)
-		if err != nil {
-			log.Error("failed to marshal ws event", sl.Err(err))
-			return
+	var clientMsgID *string
+	if req.ClientMsgID != "" {
+		if strings.TrimSpace(req.ClientMsgID) == "" || len(req.ClientMsgID) > messages.MaxClientMsgIDLen {
+			return nil, messages.ErrInvalidClientMsgID
 		}
+		clientMsgID = &req.ClientMsgID
+	}
 
-		h.hub.Broadcast(chatID, payload)
+	msg, created, err := h.messagesRepo.SendMessage(
+		ctx,
+		chatID,
+		userID,
+		req.Text,
+		req.Attachments,
+		req.ReplyToMessageID,
+		clientMsgID,
+	)
+	if err != nil {
+		return nil, err
 	}
+
+	if msg == nil {
+		return nil, messages.ErrMessageIsNil
+	}
+
+	if created {
+		h.broadcast(ctx, log, chatID, ws.MessageNew, ws.MessageNewPayload{Message: msg.Shared()})
+	}
+
+	return msg, nil
 }
 
 func (h *Handler) SetLastReadMessage() http.HandlerFunc {
@@ -202,23 +253,10 @@ func (h *Handler) SetLastReadMessage() http.HandlerFunc {
 
 		render.Status(r, http.StatusNoContent)
 
-		evt, err := ws.NewEvent(chatID, ws.MessageRead, ws.MessageReadPayload{
+		h.broadcast(r.Context(), log, chatID, ws.MessageRead, ws.MessageReadPayload{
 			UserID:            userID,
 			LastReadMessageID: savedLastRead,
 		})
-
-		if err != nil {
-			log.Error("failed to build ws event", sl.Err(err))
-			return
-		}
-
-		payload, err := json.Marshal(evt)
-		if err != nil {
-			log.Error("failed to marshal ws event", sl.Err(err))
-			return
-		}
-
-		h.hub.Broadcast(chatID, payload)
 	}
 }
 
@@ -247,10 +285,28 @@ func (h *Handler) DeleteMessage() http.HandlerFunc {
 			return
 		}
 
-		err = h.messagesRepo.DeleteMessage(
+		scope, err := messages.ParseDeleteScope(r.URL.Query().Get("scope"))
+		if err != nil {
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		userID := userhandlers.UserID(r)
+
+		canDeleteOthers, err := h.chatsPolicy.Can(r.Context(), chatID, userID, chats.PermDeleteOthersMessages)
+		if err != nil {
+			log.Error("failed to check permissions", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		unpinned, err := h.messagesRepo.DeleteMessage(
 			r.Context(),
 			chatID,
+			userID,
 			messageID,
+			scope,
+			canDeleteOthers,
 		)
 
 		if err != nil {
@@ -261,20 +317,16 @@ func (h *Handler) DeleteMessage() http.HandlerFunc {
 
 		render.Status(r, http.StatusNoContent)
 
-		evt, err := ws.NewEvent(chatID, ws.MessagesDeleted, ws.MessagesDeletePayload{IDs: []int64{messageID}})
-
-		if err != nil {
-			log.Error("failed to build ws event", sl.Err(err))
-			return
+		// "Удалить у себя" другие участники не видят
+		if scope == messages.DeleteForEveryone {
+			h.broadcast(r.Context(), log, chatID, ws.MessagesDeleted, ws.MessagesDeletePayload{IDs: []int64{messageID}})
 		}
-
-		payload, err := json.Marshal(evt)
-		if err != nil {
-			log.Error("failed to marshal ws event", sl.Err(err))
-			return
+		if unpinned {
+			h.broadcast(r.Context(), log, chatID, ws.MessageUnpinned, ws.MessageUnpinnedPayload{
+				MessageID: messageID,
+				ActorID:   userID,
+			})
 		}
-
-		h.hub.Broadcast(chatID, payload)
 	}
 }
 
@@ -300,12 +352,31 @@ func (h *Handler) DeleteMessages() http.HandlerFunc {
 		if err != nil {
 			log.Error("invalid messageIDs", sl.Err(err))
 			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		scope, err := messages.ParseDeleteScope(string(req.Scope))
+		if err != nil {
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		userID := userhandlers.UserID(r)
+
+		canDeleteOthers, err := h.chatsPolicy.Can(r.Context(), chatID, userID, chats.PermDeleteOthersMessages)
+		if err != nil {
+			log.Error("failed to check permissions", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
 		}
 
-		deletedIDs, err := h.messagesRepo.DeleteMessages(
+		deletedIDs, unpinnedIDs, err := h.messagesRepo.DeleteMessages(
 			r.Context(),
 			chatID,
+			userID,
 			req.MessageIDs,
+			scope,
+			canDeleteOthers,
 		)
 
 		if err != nil {
@@ -318,19 +389,208 @@ func (h *Handler) DeleteMessages() http.HandlerFunc {
 			MessageIDs: deletedIDs,
 		})
 
-		evt, err := ws.NewEvent(chatID, ws.MessagesDeleted, ws.MessagesDeletePayload{IDs: deletedIDs})
+		if scope == messages.DeleteForEveryone {
+			h.broadcast(r.Context(), log, chatID, ws.MessagesDeleted, ws.MessagesDeletePayload{IDs: deletedIDs})
+		}
+		for _, messageID := range unpinnedIDs {
+			h.broadcast(r.Context(), log, chatID, ws.MessageUnpinned, ws.MessageUnpinnedPayload{
+				MessageID: messageID,
+				ActorID:   userID,
+			})
+		}
+	}
+}
+
+func (h *Handler) EditMessage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.messages.edit"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		chatIDStr := chi.URLParam(r, "chatId")
+		chatID, err := strconv.ParseInt(chatIDStr, 10, 64)
+		if err != nil || chatID <= 0 {
+			log.Error("invalid chat_id", slog.String("chat_id", chatIDStr))
+			httpapi.WriteError(w, r, chats.ErrInvalidChatID)
+			return
+		}
+
+		messageIDStr := chi.URLParam(r, "messageId")
+		messageID, err := strconv.ParseInt(messageIDStr, 10, 64)
+		if err != nil || messageID <= 0 {
+			log.Error("invalid messageId", slog.String("message_id", messageIDStr))
+			httpapi.WriteError(w, r, messages.ErrInvalidMessageID)
+			return
+		}
+
+		var req messages.EditMessageRequest
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			log.Error("decode request error", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		userID := userhandlers.UserID(r)
+
+		msg, err := h.messagesRepo.EditMessage(
+			r.Context(),
+			chatID,
+			messageID,
+			userID,
+			req.Text,
+			h.cfg.EditWindow(),
+		)
+		if err != nil {
+			log.Error("failed to edit message", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, messages.CreateMessageResponse{
+			Message: *msg,
+		})
+
+		h.broadcast(r.Context(), log, chatID, ws.MessageEdited, ws.MessageEditedPayload{Message: msg.Shared()})
+	}
+}
+
+func (h *Handler) GetMessageEdits() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.messages.edits"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		chatIDStr := chi.URLParam(r, "chatId")
+		chatID, err := strconv.ParseInt(chatIDStr, 10, 64)
+		if err != nil || chatID <= 0 {
+			log.Error("invalid chat_id", slog.String("chat_id", chatIDStr))
+			httpapi.WriteError(w, r, chats.ErrInvalidChatID)
+			return
+		}
+
+		messageIDStr := chi.URLParam(r, "messageId")
+		messageID, err := strconv.ParseInt(messageIDStr, 10, 64)
+		if err != nil || messageID <= 0 {
+			log.Error("invalid messageId", slog.String("message_id", messageIDStr))
+			httpapi.WriteError(w, r, messages.ErrInvalidMessageID)
+			return
+		}
+
+		edits, err := h.messagesRepo.GetMessageEdits(r.Context(), chatID, messageID)
+		if err != nil {
+			log.Error("failed to get message edits", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, messages.GetMessageEditsResponse{
+			Edits: edits,
+		})
+	}
+}
+
+func (h *Handler) AddReaction() http.HandlerFunc {
+	return h.reactionHandler("handlers.messages.reaction.add", true)
+}
+
+func (h *Handler) RemoveReaction() http.HandlerFunc {
+	return h.reactionHandler("handlers.messages.reaction.remove", false)
+}
+
+// reactionHandler обслуживает PUT и DELETE .../reactions/{emoji}: оба
+// идемпотентны, событие уходит в WS только если что-то поменялось.
+func (h *Handler) reactionHandler(op string, add bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		chatIDStr := chi.URLParam(r, "chatId")
+		chatID, err := strconv.ParseInt(chatIDStr, 10, 64)
+		if err != nil || chatID <= 0 {
+			log.Error("invalid chat_id", slog.String("chat_id", chatIDStr))
+			httpapi.WriteError(w, r, chats.ErrInvalidChatID)
+			return
+		}
+
+		messageIDStr := chi.URLParam(r, "messageId")
+		messageID, err := strconv.ParseInt(messageIDStr, 10, 64)
+		if err != nil || messageID <= 0 {
+			log.Error("invalid messageId", slog.String("message_id", messageIDStr))
+			httpapi.WriteError(w, r, messages.ErrInvalidMessageID)
+			return
+		}
+
+		emoji, err := url.PathUnescape(chi.URLParam(r, "emoji"))
+		if err != nil || !messages.IsValidEmoji(emoji) {
+			httpapi.WriteError(w, r, messages.ErrInvalidEmoji)
+			return
+		}
 
+		userID := userhandlers.UserID(r)
+
+		var changed bool
+		if add {
+			changed, err = h.messagesRepo.AddReaction(r.Context(), chatID, messageID, userID, emoji)
+		} else {
+			changed, err = h.messagesRepo.RemoveReaction(r.Context(), chatID, messageID, userID, emoji)
+		}
 		if err != nil {
-			log.Error("failed to build ws event", sl.Err(err))
+			log.Error("failed to change reaction", sl.Err(err))
+			httpapi.WriteError(w, r, err)
 			return
 		}
 
-		payload, err := json.Marshal(evt)
+		reactions, err := h.messagesRepo.GetReactions(r.Context(), userID, []int64{messageID})
 		if err != nil {
-			log.Error("failed to marshal ws event", sl.Err(err))
+			log.Error("failed to get reactions", sl.Err(err))
+			httpapi.WriteError(w, r, err)
 			return
 		}
 
-		h.hub.Broadcast(chatID, payload)
+		resp := messages.ReactionsResponse{Reactions: reactions[messageID]}
+		if resp.Reactions == nil {
+			resp.Reactions = []messages.Reaction{}
+		}
+		render.JSON(w, r, resp)
+
+		if !changed {
+			return
+		}
+
+		typ := ws.ReactionAdded
+		if !add {
+			typ = ws.ReactionRemoved
+		}
+
+		h.broadcast(r.Context(), log, chatID, typ, ws.ReactionPayload{
+			MessageID: messageID,
+			UserID:    userID,
+			Emoji:     emoji,
+		})
+	}
+}
+
+// broadcast пишет событие в журнал чата и рассылает подписчикам. Ошибки
+// только логируются: HTTP-ответ к этому моменту уже отправлен. Изменение
+// уже сохранено, поэтому отключение клиента не должно обрывать рассылку.
+func (h *Handler) broadcast(ctx context.Context, log *slog.Logger, chatID int64, typ ws.EventType, data any) {
+	ctx = context.WithoutCancel(ctx)
+
+	evt, err := ws.NewEvent(chatID, typ, data)
+	if err != nil {
+		log.Error("failed to build ws event", sl.Err(err))
+		return
+	}
+
+	if err := h.events.Publish(ctx, evt); err != nil {
+		log.Error("failed to publish ws event", sl.Err(err))
 	}
 }
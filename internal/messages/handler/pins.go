@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/kgellert/hodatay-messenger/internal/chats"
+	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
+	"github.com/kgellert/hodatay-messenger/internal/messages"
+	"github.com/kgellert/hodatay-messenger/internal/transport/httpapi"
+	userhandlers "github.com/kgellert/hodatay-messenger/internal/users/handlers"
+	"github.com/kgellert/hodatay-messenger/internal/ws"
+)
+
+// GetPins: GET /chats/{chatId}/pins — закреплённые сообщения, от последнего.
+func (h *Handler) GetPins() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.messages.pins.get"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		chatIDStr := chi.URLParam(r, "chatId")
+		chatID, err := strconv.ParseInt(chatIDStr, 10, 64)
+		if err != nil || chatID <= 0 {
+			log.Error("invalid chat_id", slog.String("chat_id", chatIDStr))
+			httpapi.WriteError(w, r, chats.ErrInvalidChatID)
+			return
+		}
+
+		pins, err := h.messagesRepo.GetPins(r.Context(), chatID, userhandlers.UserID(r))
+		if err != nil {
+			log.Error("failed to get pins", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, messages.GetPinsResponse{
+			Pins: pins,
+		})
+	}
+}
+
+// PinMessage: POST /chats/{chatId}/pins/{messageId}. Повторный вызов отдаёт
+// существующий закреп без событий.
+func (h *Handler) PinMessage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.messages.pins.pin"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		chatID, messageID, ok := pinParams(w, r, log)
+		if !ok {
+			return
+		}
+
+		userID := userhandlers.UserID(r)
+
+		if err := h.chatsPolicy.Check(r.Context(), chatID, userID, chats.PermPinMessages); err != nil {
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		pin, pinned, err := h.messagesRepo.PinMessage(r.Context(), chatID, messageID, userID)
+		if err != nil {
+			log.Error("failed to pin message", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, messages.PinResponse{
+			Pin: *pin,
+		})
+
+		if !pinned {
+			return
+		}
+
+		shared := *pin
+		shared.Message = pin.Message.Shared()
+		h.broadcast(r.Context(), log, chatID, ws.MessagePinned, ws.MessagePinnedPayload{Pin: shared})
+
+		msg, err := h.messagesRepo.CreateSystemMessage(context.WithoutCancel(r.Context()), chatID, userID, messages.SystemPayload{
+			Event:     messages.SystemMessagePinned,
+			MessageID: messageID,
+		})
+		if err != nil {
+			log.Error("failed to create system message", sl.Err(err))
+			return
+		}
+
+		h.broadcast(r.Context(), log, chatID, ws.MessageNew, ws.MessageNewPayload{Message: *msg})
+	}
+}
+
+// UnpinMessage: DELETE /chats/{chatId}/pins/{messageId}, идемпотентный.
+func (h *Handler) UnpinMessage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.messages.pins.unpin"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		chatID, messageID, ok := pinParams(w, r, log)
+		if !ok {
+			return
+		}
+
+		userID := userhandlers.UserID(r)
+
+		if err := h.chatsPolicy.Check(r.Context(), chatID, userID, chats.PermPinMessages); err != nil {
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		unpinned, err := h.messagesRepo.UnpinMessage(r.Context(), chatID, messageID)
+		if err != nil {
+			log.Error("failed to unpin message", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+		if unpinned {
+			h.broadcast(r.Context(), log, chatID, ws.MessageUnpinned, ws.MessageUnpinnedPayload{
+				MessageID: messageID,
+				ActorID:   userID,
+			})
+		}
+	}
+}
+
+func pinParams(w http.ResponseWriter, r *http.Request, log *slog.Logger) (int64, int64, bool) {
+	chatIDStr := chi.URLParam(r, "chatId")
+	chatID, err := strconv.ParseInt(chatIDStr, 10, 64)
+	if err != nil || chatID <= 0 {
+		log.Error("invalid chat_id", slog.String("chat_id", chatIDStr))
+		httpapi.WriteError(w, r, chats.ErrInvalidChatID)
+		return 0, 0, false
+	}
+
+	messageIDStr := chi.URLParam(r, "messageId")
+	messageID, err := strconv.ParseInt(messageIDStr, 10, 64)
+	if err != nil || messageID <= 0 {
+		log.Error("invalid messageId", slog.String("message_id", messageIDStr))
+		httpapi.WriteError(w, r, messages.ErrInvalidMessageID)
+		return 0, 0, false
+	}
+
+	return chatID, messageID, true
+}
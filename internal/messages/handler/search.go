@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/kgellert/hodatay-messenger/internal/chats"
+	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
+	"github.com/kgellert/hodatay-messenger/internal/messages"
+	"github.com/kgellert/hodatay-messenger/internal/transport/httpapi"
+	userhandlers "github.com/kgellert/hodatay-messenger/internal/users/handlers"
+)
+
+// SearchChatMessages: GET /chats/{chatId}/messages/search?q=
+func (h *Handler) SearchChatMessages() http.HandlerFunc {
+	return h.searchHandler("handlers.messages.search.chat", true)
+}
+
+// SearchMessages: GET /search/messages?q= по всем чатам пользователя.
+func (h *Handler) SearchMessages() http.HandlerFunc {
+	return h.searchHandler("handlers.messages.search.global", false)
+}
+
+func (h *Handler) searchHandler(op string, inChat bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const defaultLimit = 20
+		const maxLimit = 100
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		params := messages.SearchMessagesParams{
+			Query: strings.TrimSpace(r.URL.Query().Get("q")),
+			Limit: defaultLimit,
+		}
+
+		if params.Query == "" {
+			httpapi.WriteError(w, r, messages.ErrEmptySearchQuery)
+			return
+		}
+
+		if inChat {
+			chatIDStr := chi.URLParam(r, "chatId")
+			chatID, err := strconv.ParseInt(chatIDStr, 10, 64)
+			if err != nil || chatID <= 0 {
+				log.Error("invalid chat_id", slog.String("chat_id", chatIDStr))
+				httpapi.WriteError(w, r, chats.ErrInvalidChatID)
+				return
+			}
+			params.ChatID = &chatID
+		}
+
+		if lStr := r.URL.Query().Get("limit"); lStr != "" {
+			parsed, err := strconv.Atoi(lStr)
+			if err != nil || parsed <= 0 {
+				httpapi.WriteError(w, r, messages.ErrInvalidLimit)
+				return
+			}
+			params.Limit = min(parsed, maxLimit)
+		}
+
+		if raw := r.URL.Query().Get("before_id"); raw != "" {
+			id, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || id <= 0 {
+				httpapi.WriteError(w, r, messages.ErrInvalidCursor)
+				return
+			}
+			params.BeforeID = &id
+		}
+
+		page, err := h.messagesRepo.SearchMessages(r.Context(), userhandlers.UserID(r), params)
+		if err != nil {
+			log.Error("failed to search messages", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, messages.SearchMessagesResponse{
+			Results: page.Hits,
+			HasMore: page.HasMore,
+		})
+	}
+}
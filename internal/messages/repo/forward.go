@@ -0,0 +1,186 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kgellert/hodatay-messenger/internal/messages"
+	messagesdomain "github.com/kgellert/hodatay-messenger/internal/messages"
+	"github.com/lib/pq"
+)
+
+// ForwardMessages копирует сообщения req.FromChatID в каждый чат
+// req.ToChatIDs от имени userID: текст и строки attachments с тем же
+// file_id, без повторной загрузки файлов. Ответы не переносятся.
+// Членство userID в чатах проверяет вызывающий.
+func (s *Repo) ForwardMessages(
+	ctx context.Context,
+	userID int64,
+	req messagesdomain.ForwardMessagesRequest,
+) ([]messagesdomain.ForwardedMessages, error) {
+
+	const op = "storage.postgres.ForwardMessages"
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: begin: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var sourceIDs []int64
+	if err := tx.SelectContext(ctx, &sourceIDs, `
+		SELECT m.id
+		FROM messages m
+		WHERE m.chat_id = $1 AND m.id = ANY($2)
+			AND m.kind = 'user'
+			AND m.deleted_at IS NULL
+			AND `+notHiddenFor("$3")+`
+		ORDER BY m.created_at, m.id
+	`, req.FromChatID, pq.Array(req.MessageIDs), userID); err != nil {
+		return nil, fmt.Errorf("%s: select sources: %w", op, err)
+	}
+
+	if len(sourceIDs) == 0 {
+		return nil, messages.ErrMessagesIsNotExist
+	}
+
+	var targets []int64
+	for _, chatID := range req.ToChatIDs {
+		if !slices.Contains(targets, chatID) {
+			targets = append(targets, chatID)
+		}
+	}
+
+	result := make([]messagesdomain.ForwardedMessages, 0, len(targets))
+	for _, chatID := range targets {
+		msgs, err := forwardToChat(ctx, tx, userID, chatID, sourceIDs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: chat %d: %w", op, chatID, err)
+		}
+		result = append(result, messagesdomain.ForwardedMessages{
+			ChatID:   chatID,
+			Messages: msgs,
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s: commit: %w", op, err)
+	}
+
+	return result, nil
+}
+
+func forwardToChat(
+	ctx context.Context,
+	tx *sqlx.Tx,
+	userID, chatID int64,
+	sourceIDs []int64,
+) ([]messagesdomain.Message, error) {
+
+	ids := make([]int64, 0, len(sourceIDs))
+	var lastAt time.Time
+
+	for _, sourceID := range sourceIDs {
+		// У пересланного пересланного сохраняем первоисточник
+		var inserted struct {
+			ID        int64     `db:"id"`
+			CreatedAt time.Time `db:"created_at"`
+		}
+		if err := tx.GetContext(ctx, &inserted, `
+			INSERT INTO messages (
+				chat_id, sender_user_id, text,
+				forwarded_from_user_id, forwarded_from_chat_id, forwarded_from_message_id
+			)
+			SELECT $1, $2, m.text,
+				CASE WHEN f.forwarded THEN m.forwarded_from_user_id ELSE m.sender_user_id END,
+				CASE WHEN f.forwarded THEN m.forwarded_from_chat_id ELSE m.chat_id END,
+				CASE WHEN f.forwarded THEN m.forwarded_from_message_id ELSE m.id END
+			FROM messages m,
+				LATERAL (SELECT m.forwarded_from_user_id IS NOT NULL
+					OR m.forwarded_from_chat_id IS NOT NULL
+					OR m.forwarded_from_message_id IS NOT NULL AS forwarded) f
+			WHERE m.id = $3
+			RETURNING id, created_at
+		`, chatID, userID, sourceID); err != nil {
+			return nil, fmt.Errorf("insert message: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO attachments (message_id, file_id, content_type, filename, size, width, height, duration_ms, waveform_u8)
+			SELECT $1, file_id, content_type, filename, size, width, height, duration_ms, waveform_u8
+			FROM attachments
+			WHERE message_id = $2
+			ORDER BY id
+		`, inserted.ID, sourceID); err != nil {
+			return nil, fmt.Errorf("copy attachments: %w", err)
+		}
+
+		ids = append(ids, inserted.ID)
+		lastAt = inserted.CreatedAt
+	}
+
+	if err := touchChat(ctx, tx, chatID, lastAt); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryxContext(ctx, messagesQuery(`
+			SELECT `+baseMessageColumns+`
+			FROM messages
+			WHERE chat_id = $1 AND id = ANY($2)
+	`), chatID, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("query messages: %w", err)
+	}
+	defer rows.Close()
+
+	msgs, err := scanMessages(rows)
+	if err != nil {
+		return nil, fmt.Errorf("scan messages: %w", err)
+	}
+
+	// Пересылающий состоит в исходном чате, ему chat_id виден
+	if err := attachForwardedChats(ctx, tx, userID, msgs); err != nil {
+		return nil, fmt.Errorf("attach forwarded chats: %w", err)
+	}
+
+	return msgs, nil
+}
+
+// attachForwardedChats открывает ForwardedFrom.ChatID у сообщений из msgs,
+// исходный чат которых userID видит как участник.
+func attachForwardedChats(ctx context.Context, q sqlx.QueryerContext, userID int64, msgs []messagesdomain.Message) error {
+	var chatIDs []int64
+	for _, m := range msgs {
+		if m.ForwardedFrom == nil {
+			continue
+		}
+		if chatID, ok := m.ForwardedFrom.SourceChatID(); ok {
+			chatIDs = append(chatIDs, chatID)
+		}
+	}
+	if len(chatIDs) == 0 {
+		return nil
+	}
+
+	var memberOf []int64
+	if err := sqlx.SelectContext(ctx, q, &memberOf, `
+		SELECT chat_id FROM chat_participants
+		WHERE user_id = $1 AND chat_id = ANY($2)
+	`, userID, pq.Array(chatIDs)); err != nil {
+		return fmt.Errorf("select source chats: %w", err)
+	}
+
+	for i := range msgs {
+		if msgs[i].ForwardedFrom == nil {
+			continue
+		}
+		if chatID, ok := msgs[i].ForwardedFrom.SourceChatID(); ok && slices.Contains(memberOf, chatID) {
+			msgs[i].ForwardedFrom.RevealChat()
+		}
+	}
+
+	return nil
+}
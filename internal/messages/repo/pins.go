@@ -0,0 +1,231 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kgellert/hodatay-messenger/internal/messages"
+	messagesdomain "github.com/kgellert/hodatay-messenger/internal/messages"
+	"github.com/lib/pq"
+)
+
+type pinRow struct {
+	MessageID int64         `db:"message_id"`
+	PinnedBy  sql.NullInt64 `db:"pinned_by"`
+	PinnedAt  time.Time     `db:"pinned_at"`
+}
+
+// PinMessage закрепляет сообщение. Возвращает false, если оно уже было
+// закреплено; pin в этом случае — существующий закреп.
+func (s *Repo) PinMessage(ctx context.Context, chatID, messageID, userID int64) (*messagesdomain.Pin, bool, error) {
+	const op = "storage.postgres.PinMessage"
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: begin: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// FOR SHARE — чтобы не закрепить сообщение, которое в этот момент удаляют
+	var kind messagesdomain.MessageKind
+	err = tx.GetContext(ctx, &kind, `
+		SELECT kind FROM messages
+		WHERE chat_id = $1 AND id = $2 AND deleted_at IS NULL
+		FOR SHARE
+	`, chatID, messageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, messages.ErrMessageIsNotExist
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: select message: %w", op, err)
+	}
+	if kind != messagesdomain.KindUser {
+		return nil, false, messages.ErrSystemMessagePin
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO pinned_messages (message_id, chat_id, pinned_by)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (message_id) DO NOTHING
+	`, messageID, chatID, userID)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: insert: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: rows affected: %w", op, err)
+	}
+
+	var rows []pinRow
+	if err := tx.SelectContext(ctx, &rows, `
+		SELECT message_id, pinned_by, pinned_at
+		FROM pinned_messages
+		WHERE message_id = $1
+	`, messageID); err != nil {
+		return nil, false, fmt.Errorf("%s: select pin: %w", op, err)
+	}
+
+	pins, err := loadPins(ctx, tx, chatID, userID, rows)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+	if len(pins) == 0 {
+		return nil, false, messages.ErrMessageIsNotExist
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("%s: commit: %w", op, err)
+	}
+
+	return &pins[0], n > 0, nil
+}
+
+// UnpinMessage снимает закреп. Возвращает false, если сообщение не было закреплено.
+func (s *Repo) UnpinMessage(ctx context.Context, chatID, messageID int64) (bool, error) {
+	const op = "storage.postgres.UnpinMessage"
+
+	if err := s.ensureMessageInChat(ctx, chatID, messageID); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM pinned_messages
+		WHERE chat_id = $1 AND message_id = $2
+	`, chatID, messageID)
+	if err != nil {
+		return false, fmt.Errorf("%s: delete: %w", op, err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("%s: rows affected: %w", op, err)
+	}
+
+	return rows > 0, nil
+}
+
+// GetPins возвращает закрепы чата, кроме сообщений, скрытых userID у себя.
+func (s *Repo) GetPins(ctx context.Context, chatID, userID int64) ([]messagesdomain.Pin, error) {
+	const op = "storage.postgres.GetPins"
+
+	var rows []pinRow
+	if err := s.db.SelectContext(ctx, &rows, `
+		SELECT p.message_id, p.pinned_by, p.pinned_at
+		FROM pinned_messages p
+		JOIN messages m ON m.id = p.message_id
+		WHERE p.chat_id = $1 AND `+notHiddenFor("$2")+`
+		ORDER BY p.pinned_at DESC, p.message_id DESC
+	`, chatID, userID); err != nil {
+		return nil, fmt.Errorf("%s: select: %w", op, err)
+	}
+
+	pins, err := loadPins(ctx, s.db, chatID, userID, rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return pins, nil
+}
+
+// GetLatestPin — последний закреп чата для ChatInfo, кроме сообщений,
+// скрытых userID у себя, как в GetPins. Реакции у сообщения не заполняются.
+func (s *Repo) GetLatestPin(ctx context.Context, chatID, userID int64) (*messagesdomain.Pin, error) {
+	const op = "storage.postgres.GetLatestPin"
+
+	var row pinRow
+	err := s.db.GetContext(ctx, &row, `
+		SELECT p.message_id, p.pinned_by, p.pinned_at
+		FROM pinned_messages p
+		JOIN messages m ON m.id = p.message_id
+		WHERE p.chat_id = $1 AND `+notHiddenFor("$2")+`
+		ORDER BY p.pinned_at DESC, p.message_id DESC
+		LIMIT 1
+	`, chatID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: select: %w", op, err)
+	}
+
+	msg, err := s.getMessage(ctx, s.db, chatID, row.MessageID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	pin := newPin(*msg, row)
+	return &pin, nil
+}
+
+// loadPins подтягивает к закрепам сообщения с вложениями и реакциями,
+// сохраняя порядок rows.
+func loadPins(
+	ctx context.Context,
+	q sqlx.QueryerContext,
+	chatID, userID int64,
+	rows []pinRow,
+) ([]messagesdomain.Pin, error) {
+
+	pins := make([]messagesdomain.Pin, 0, len(rows))
+	if len(rows) == 0 {
+		return pins, nil
+	}
+
+	ids := make([]int64, 0, len(rows))
+	for _, r := range rows {
+		ids = append(ids, r.MessageID)
+	}
+
+	msgRows, err := q.QueryxContext(ctx, messagesQuery(`
+			SELECT `+baseMessageColumns+`
+			FROM messages
+			WHERE chat_id = $1 AND id = ANY($2)
+	`), chatID, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("query messages: %w", err)
+	}
+	defer msgRows.Close()
+
+	msgs, err := scanMessages(msgRows)
+	if err != nil {
+		return nil, fmt.Errorf("scan messages: %w", err)
+	}
+
+	if err := attachReactions(ctx, q, userID, msgs); err != nil {
+		return nil, fmt.Errorf("attach reactions: %w", err)
+	}
+	if err := attachForwardedChats(ctx, q, userID, msgs); err != nil {
+		return nil, fmt.Errorf("attach forwarded chats: %w", err)
+	}
+
+	byID := make(map[int64]messagesdomain.Message, len(msgs))
+	for _, m := range msgs {
+		byID[m.ID] = m
+	}
+
+	for _, r := range rows {
+		if m, ok := byID[r.MessageID]; ok {
+			pins = append(pins, newPin(m, r))
+		}
+	}
+
+	return pins, nil
+}
+
+func newPin(msg messagesdomain.Message, row pinRow) messagesdomain.Pin {
+	var pinnedBy *int64
+	if row.PinnedBy.Valid {
+		pinnedBy = &row.PinnedBy.Int64
+	}
+
+	return messagesdomain.Pin{
+		Message:  msg,
+		PinnedBy: pinnedBy,
+		PinnedAt: row.PinnedAt,
+	}
+}
@@ -0,0 +1,141 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kgellert/hodatay-messenger/internal/messages"
+	messagesdomain "github.com/kgellert/hodatay-messenger/internal/messages"
+	"github.com/lib/pq"
+)
+
+// AddReaction ставит реакцию. Возвращает false, если такая реакция уже стояла.
+func (s *Repo) AddReaction(ctx context.Context, chatID, messageID, userID int64, emoji string) (bool, error) {
+	const op = "storage.postgres.AddReaction"
+
+	if err := s.ensureMessageInChat(ctx, chatID, messageID); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO message_reactions (message_id, user_id, emoji)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (message_id, user_id, emoji) DO NOTHING
+	`, messageID, userID, emoji)
+	if err != nil {
+		return false, fmt.Errorf("%s: insert: %w", op, err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("%s: rows affected: %w", op, err)
+	}
+
+	return rows > 0, nil
+}
+
+// RemoveReaction снимает реакцию. Возвращает false, если реакции не было.
+func (s *Repo) RemoveReaction(ctx context.Context, chatID, messageID, userID int64, emoji string) (bool, error) {
+	const op = "storage.postgres.RemoveReaction"
+
+	if err := s.ensureMessageInChat(ctx, chatID, messageID); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM message_reactions
+		WHERE message_id = $1 AND user_id = $2 AND emoji = $3
+	`, messageID, userID, emoji)
+	if err != nil {
+		return false, fmt.Errorf("%s: delete: %w", op, err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("%s: rows affected: %w", op, err)
+	}
+
+	return rows > 0, nil
+}
+
+func (s *Repo) GetReactions(ctx context.Context, userID int64, messageIDs []int64) (map[int64][]messagesdomain.Reaction, error) {
+	return getReactions(ctx, s.db, userID, messageIDs)
+}
+
+func getReactions(
+	ctx context.Context,
+	q sqlx.QueryerContext,
+	userID int64,
+	messageIDs []int64,
+) (map[int64][]messagesdomain.Reaction, error) {
+
+	const op = "storage.postgres.GetReactions"
+
+	result := make(map[int64][]messagesdomain.Reaction, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return result, nil
+	}
+
+	var rows []struct {
+		MessageID int64 `db:"message_id"`
+		messagesdomain.Reaction
+	}
+	err := sqlx.SelectContext(ctx, q, &rows, `
+		SELECT
+			message_id,
+			emoji,
+			COUNT(*)              AS count,
+			BOOL_OR(user_id = $2) AS reacted_by_me
+		FROM message_reactions
+		WHERE message_id = ANY($1)
+		GROUP BY message_id, emoji
+		ORDER BY message_id, MIN(created_at), emoji
+	`, pq.Array(messageIDs), userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: select: %w", op, err)
+	}
+
+	for _, r := range rows {
+		result[r.MessageID] = append(result[r.MessageID], r.Reaction)
+	}
+
+	return result, nil
+}
+
+// attachReactions проставляет Reactions каждому сообщению из msgs.
+func attachReactions(ctx context.Context, q sqlx.QueryerContext, userID int64, msgs []messagesdomain.Message) error {
+	ids := make([]int64, 0, len(msgs))
+	for _, m := range msgs {
+		ids = append(ids, m.ID)
+	}
+
+	reactions, err := getReactions(ctx, q, userID, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range msgs {
+		if rs, ok := reactions[msgs[i].ID]; ok {
+			msgs[i].Reactions = rs
+		}
+	}
+
+	return nil
+}
+
+func (s *Repo) ensureMessageInChat(ctx context.Context, chatID, messageID int64) error {
+	var exists bool
+	err := s.db.GetContext(ctx, &exists, `
+		SELECT EXISTS (SELECT 1 FROM messages WHERE chat_id = $1 AND id = $2 AND deleted_at IS NULL)
+	`, chatID, messageID)
+	if err != nil {
+		return fmt.Errorf("select message: %w", err)
+	}
+
+	if !exists {
+		return messages.ErrMessageIsNotExist
+	}
+
+	return nil
+}
@@ -2,7 +2,11 @@ package repo
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/kgellert/hodatay-messenger/internal/messages"
@@ -26,34 +30,52 @@ func (s *Repo) SendMessage(
 	text string,
 	attachments []messagesdomain.CreateMessageAttachment,
 	replyToMessageID *int64,
-) (*messagesdomain.Message, error) {
+	clientMsgID *string,
+) (*messagesdomain.Message, bool, error) {
 
 	const op = "storage.postgres.SendMessage"
 
 	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("%s: begin tx: %w", op, err)
+		return nil, false, fmt.Errorf("%s: begin tx: %w", op, err)
 	}
 	defer tx.Rollback()
 
+	// Ответить можно только на сообщение из этого же чата
+	if replyToMessageID != nil {
+		var exists int
+		err := tx.GetContext(ctx, &exists, `SELECT 1 FROM messages WHERE id = $2 AND chat_id = $1`, chatID, *replyToMessageID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, messages.ErrMessageIsNotExist
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: select reply target: %w", op, err)
+		}
+	}
+
 	rows, err := tx.QueryxContext(
 		ctx,
 		`
 		WITH inserted AS (
-			INSERT INTO messages (chat_id, sender_user_id, text, reply_to_message_id)
-			VALUES ($1, $2, $3, $4)
-			RETURNING id, chat_id, sender_user_id, text, created_at, reply_to_message_id
+			INSERT INTO messages (chat_id, sender_user_id, text, reply_to_message_id, client_msg_id)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (chat_id, sender_user_id, client_msg_id) DO NOTHING
+			RETURNING id, chat_id, sender_user_id, kind, payload, text, created_at, edited_at, reply_to_message_id
 		)
 		SELECT
 			i.id,
 			i.sender_user_id,
+			i.kind,
+			i.payload,
 			i.text,
 			i.created_at,
+			i.edited_at,
 
 			rm.id AS "reply_to.id",
 			rm.sender_user_id AS "reply_to.sender_user_id",
-			rm.text AS "reply_to.text",
+			CASE WHEN rm.deleted_at IS NULL THEN rm.text ELSE '' END AS "reply_to.text",
 			rm.created_at AS "reply_to.created_at",
+			rm.deleted_at AS "reply_to.deleted_at",
 
 			ra.id AS "reply_to.attachment.id",
 			ra.file_id AS "reply_to.attachment.file_id",
@@ -66,13 +88,13 @@ func (s *Repo) SendMessage(
 			ra.waveform_u8 AS "reply_to.attachment.waveform_u8"
 
 		FROM inserted i
-		LEFT JOIN messages rm ON i.reply_to_message_id = rm.id
-		LEFT JOIN attachments ra ON ra.message_id = rm.id
+		LEFT JOIN messages rm ON i.reply_to_message_id = rm.id AND rm.chat_id = i.chat_id
+		LEFT JOIN attachments ra ON ra.message_id = rm.id AND rm.deleted_at IS NULL
 		`,
-		chatID, userID, text, replyToMessageID,
+		chatID, userID, text, replyToMessageID, clientMsgID,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("%s: query message: %w", op, err)
+		return nil, false, fmt.Errorf("%s: query message: %w", op, err)
 	}
 	defer rows.Close()
 
@@ -80,16 +102,25 @@ func (s *Repo) SendMessage(
 	for rows.Next() {
 		var r messagesdomain.MessageRow
 		if err := rows.StructScan(&r); err != nil {
-			return nil, fmt.Errorf("%s: scan: %w", op, err)
+			return nil, false, fmt.Errorf("%s: scan: %w", op, err)
 		}
 		resultRows = append(resultRows, r)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("%s: rows error: %w", op, err)
+		return nil, false, fmt.Errorf("%s: rows error: %w", op, err)
 	}
 
 	if len(resultRows) == 0 {
-		return nil, fmt.Errorf("%s: no rows returned", op)
+		if clientMsgID == nil {
+			return nil, false, fmt.Errorf("%s: no rows returned", op)
+		}
+
+		// Конфликт по client_msg_id: это повтор, отдаём исходное сообщение
+		msg, err := s.getSentMessage(ctx, tx, chatID, userID, *clientMsgID)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", op, err)
+		}
+		return msg, false, nil
 	}
 
 	lastMessageRow := resultRows[0]
@@ -129,11 +160,11 @@ func (s *Repo) SendMessage(
 		)
 
 		if err != nil {
-			return nil, fmt.Errorf("%s: select upload: %w", op, err)
+			return nil, false, fmt.Errorf("%s: select upload: %w", op, err)
 		}
 
 		if uploadRow.Status != string(uploadsdomain.StatusReady) {
-			return nil, fmt.Errorf("%s: upload is not confirmed: %w", op, err)
+			return nil, false, fmt.Errorf("%s: upload is not confirmed: %w", op, err)
 		}
 
 		var attachmentRow uploadsdomain.AttachmentRow
@@ -156,20 +187,110 @@ func (s *Repo) SendMessage(
 		)
 
 		if err != nil {
-			return nil, fmt.Errorf("%s: insert attachment: %w", op, err)
+			return nil, false, fmt.Errorf("%s: insert attachment: %w", op, err)
 		}
 
 		nAtt := uploadsdomain.NewAttachmentFromRow(attachmentRow)
 		atts = append(atts, nAtt)
 	}
 
+	if err := touchChat(ctx, tx, chatID, msg.CreatedAt); err != nil {
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+
 	if err := tx.Commit(); err != nil {
-		return nil, fmt.Errorf("%s: commit tx: %w", op, err)
+		return nil, false, fmt.Errorf("%s: commit tx: %w", op, err)
 	}
 
 	msg.Attachments = atts
 
-	return &msg, nil
+	return &msg, true, nil
+}
+
+func (s *Repo) getSentMessage(ctx context.Context, tx *sqlx.Tx, chatID, userID int64, clientMsgID string) (*messagesdomain.Message, error) {
+	var messageID int64
+	if err := tx.GetContext(ctx, &messageID, `
+		SELECT id FROM messages
+		WHERE chat_id = $1 AND sender_user_id = $2 AND client_msg_id = $3
+	`, chatID, userID, clientMsgID); err != nil {
+		return nil, fmt.Errorf("select by client_msg_id: %w", err)
+	}
+
+	msg, err := s.getMessage(ctx, tx, chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := messagesdomain.CheckResent(*msg); err != nil {
+		return nil, err
+	}
+
+	msgs := []messagesdomain.Message{*msg}
+	if err := attachReactions(ctx, tx, userID, msgs); err != nil {
+		return nil, fmt.Errorf("attach reactions: %w", err)
+	}
+	if err := attachForwardedChats(ctx, tx, userID, msgs); err != nil {
+		return nil, fmt.Errorf("attach forwarded chats: %w", err)
+	}
+
+	return &msgs[0], nil
+}
+
+// CreateSystemMessage добавляет в чат системное сообщение: текста нет,
+// событие лежит в payload, отправитель — тот, кто его совершил.
+func (s *Repo) CreateSystemMessage(
+	ctx context.Context,
+	chatID,
+	actorID int64,
+	payload messagesdomain.SystemPayload,
+) (*messagesdomain.Message, error) {
+
+	const op = "storage.postgres.CreateSystemMessage"
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: begin: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var inserted struct {
+		ID        int64     `db:"id"`
+		CreatedAt time.Time `db:"created_at"`
+	}
+	if err := tx.GetContext(ctx, &inserted, `
+		INSERT INTO messages (chat_id, sender_user_id, kind, payload, text)
+		VALUES ($1, $2, 'system', $3, '')
+		RETURNING id, created_at
+	`, chatID, actorID, payload); err != nil {
+		return nil, fmt.Errorf("%s: insert: %w", op, err)
+	}
+
+	if err := touchChat(ctx, tx, chatID, inserted.CreatedAt); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	msg, err := s.getMessage(ctx, tx, chatID, inserted.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s: commit: %w", op, err)
+	}
+
+	return msg, nil
+}
+
+// touchChat сдвигает last_activity_at чата, по которому сортируется список
+// чатов. GREATEST — чтобы не откатить время назад при гонке транзакций.
+func touchChat(ctx context.Context, tx *sqlx.Tx, chatID int64, at time.Time) error {
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE chats SET last_activity_at = GREATEST(last_activity_at, $2)
+		WHERE id = $1
+	`, chatID, at); err != nil {
+		return fmt.Errorf("touch chat: %w", err)
+	}
+	return nil
 }
 
 func (s *Repo) SetLastReadMessage(ctx context.Context, chatID, userID, lastReadMessageID int64) (int64, error) {
@@ -224,28 +345,43 @@ func (s *Repo) SetLastReadMessage(ctx context.Context, chatID, userID, lastReadM
 	return saved, nil
 }
 
-func (s *Repo) GetMessages(ctx context.Context, chatID int64, limit, offset int) ([]messagesdomain.Message, error) {
-	const op = "storage.postgres.GetMessages"
+// baseMessageColumns — колонки, которые messagesQuery ждёт от base_messages.
+const baseMessageColumns = `id, chat_id, sender_user_id, kind, payload, text, created_at, edited_at, reply_to_message_id, deleted_at, deleted_by,
+	forwarded_from_user_id, forwarded_from_chat_id, forwarded_from_message_id`
 
-	rows, err := s.db.QueryxContext(ctx, `
-		WITH base_messages AS (
-			SELECT id, sender_user_id, text, created_at, reply_to_message_id
-			FROM messages
-			WHERE chat_id = $1
-			ORDER BY created_at DESC
-			LIMIT $2 OFFSET $3
-		),
+// notHiddenFor — условие на messages m: сообщение не скрыто пользователем
+// с параметром userParam через "удалить у себя".
+func notHiddenFor(userParam string) string {
+	return `NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.user_id = ` + userParam + ` AND h.message_id = m.id)`
+}
+
+// messagesQuery оборачивает CTE base_messages (см. baseMessageColumns) в общий
+// SELECT с ответом и вложениями. У удалённых у всех сообщений (и цитат)
+// текст и вложения скрываются.
+func messagesQuery(baseMessages string) string {
+	return `
+		WITH base_messages AS (` + baseMessages + `),
 		m AS (
 			SELECT
 				bm.id,
 				bm.sender_user_id,
-				bm.text,
+				bm.kind,
+				bm.payload,
+				CASE WHEN bm.deleted_at IS NULL THEN bm.text ELSE '' END AS text,
 				bm.created_at,
+				bm.edited_at,
+				bm.deleted_at,
+				bm.deleted_by,
+
+				bm.forwarded_from_user_id    AS "forwarded_from.user_id",
+				bm.forwarded_from_chat_id    AS "forwarded_from.chat_id",
+				bm.forwarded_from_message_id AS "forwarded_from.message_id",
 
 				rm.id             AS "reply_to.id",
 				rm.sender_user_id AS "reply_to.sender_user_id",
-				rm.text           AS "reply_to.text",
+				CASE WHEN rm.deleted_at IS NULL THEN rm.text ELSE '' END AS "reply_to.text",
 				rm.created_at     AS "reply_to.created_at",
+				rm.deleted_at     AS "reply_to.deleted_at",
 
 				a.id              AS "attachment.id",
 				a.file_id         AS "attachment.file_id",
@@ -264,21 +400,200 @@ func (s *Repo) GetMessages(ctx context.Context, chatID int64, limit, offset int)
 				ra.size           AS "reply_to.attachment.size",
 				ra.width          AS "reply_to.attachment.width",
 				ra.height         AS "reply_to.attachment.height",
+				ra.duration_ms    AS "reply_to.attachment.duration_ms",
 				ra.waveform_u8    AS "reply_to.attachment.waveform_u8"
 			FROM base_messages bm
-			LEFT JOIN messages rm ON bm.reply_to_message_id = rm.id
-			LEFT JOIN attachments a ON a.message_id = bm.id
-			LEFT JOIN attachments ra ON ra.message_id = rm.id
+			LEFT JOIN messages rm ON bm.reply_to_message_id = rm.id AND rm.chat_id = bm.chat_id
+			LEFT JOIN attachments a ON a.message_id = bm.id AND bm.deleted_at IS NULL
+			LEFT JOIN attachments ra ON ra.message_id = rm.id AND rm.deleted_at IS NULL
 		)
 	SELECT *
 	FROM m
-	ORDER BY m.created_at ASC
-	`, chatID, limit, offset)
+	ORDER BY m.created_at ASC, m.id ASC, "attachment.id" ASC, "reply_to.attachment.id" ASC
+	`
+}
+
+func (s *Repo) GetMessages(
+	ctx context.Context,
+	chatID,
+	userID int64,
+	params messagesdomain.GetMessagesParams,
+) (*messagesdomain.MessagesPage, error) {
+
+	const op = "storage.postgres.GetMessages"
+
+	page := &messagesdomain.MessagesPage{}
+
+	var (
+		olderIDs, newerIDs []int64
+		err                error
+	)
+
+	switch {
+	case params.BeforeID != nil:
+		anchor, err := s.messageCursor(ctx, chatID, *params.BeforeID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		olderIDs, page.HasMoreBefore, err = s.selectMessageIDs(ctx, chatID, userID, anchor, true, false, params.Limit)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		page.HasMoreAfter = true
+
+	case params.AfterID != nil:
+		anchor, err := s.messageCursor(ctx, chatID, *params.AfterID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		newerIDs, page.HasMoreAfter, err = s.selectMessageIDs(ctx, chatID, userID, anchor, false, false, params.Limit)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		page.HasMoreBefore = true
+
+	case params.AroundID != nil:
+		anchor, err := s.messageCursor(ctx, chatID, *params.AroundID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		// Старшая половина включает само сообщение
+		olderLimit := (params.Limit + 1) / 2
+		olderIDs, page.HasMoreBefore, err = s.selectMessageIDs(ctx, chatID, userID, anchor, true, true, olderLimit)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		newerIDs, page.HasMoreAfter, err = s.selectMessageIDs(ctx, chatID, userID, anchor, false, false, params.Limit-olderLimit)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+	default:
+		olderIDs, page.HasMoreBefore, err = s.selectMessageIDs(ctx, chatID, userID, nil, true, false, params.Limit)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	ids := append(olderIDs, newerIDs...)
+
+	rows, err := s.db.QueryxContext(ctx, messagesQuery(`
+			SELECT `+baseMessageColumns+`
+			FROM messages
+			WHERE chat_id = $1 AND id = ANY($2)
+	`), chatID, pq.Array(ids))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s: query: %w", op, err)
+	}
+	defer rows.Close()
+
+	page.Messages, err = scanMessages(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := attachReactions(ctx, s.db, userID, page.Messages); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := attachForwardedChats(ctx, s.db, userID, page.Messages); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return page, nil
+}
+
+type messageCursor struct {
+	ID        int64     `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func (s *Repo) messageCursor(ctx context.Context, chatID, messageID int64) (*messageCursor, error) {
+	var c messageCursor
+	err := s.db.GetContext(ctx, &c, `
+		SELECT id, created_at
+		FROM messages
+		WHERE chat_id = $1 AND id = $2
+	`, chatID, messageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, messages.ErrMessageIsNotExist
+	}
+	if err != nil {
+		return nil, fmt.Errorf("select cursor: %w", err)
+	}
+
+	return &c, nil
+}
+
+// selectMessageIDs идёт от курсора в одну сторону по (created_at, id) и берёт
+// до limit id, пропуская скрытые userID. Второе значение — есть ли в этой
+// стороне ещё сообщения. Без курсора older=true отдаёт самые новые сообщения чата.
+func (s *Repo) selectMessageIDs(
+	ctx context.Context,
+	chatID int64,
+	userID int64,
+	cursor *messageCursor,
+	older bool,
+	inclusive bool,
+	limit int,
+) ([]int64, bool, error) {
+
+	cmp, order := ">", "ASC"
+	if older {
+		cmp, order = "<", "DESC"
+	}
+	if inclusive {
+		cmp += "="
+	}
+
+	query := `SELECT id FROM messages m WHERE chat_id = $1 AND ` + notHiddenFor("$2")
+	args := []any{chatID, userID}
+	if cursor != nil {
+		query += ` AND (created_at, id) ` + cmp + ` ($3, $4)`
+		args = append(args, cursor.CreatedAt, cursor.ID)
+	}
+	query += fmt.Sprintf(` ORDER BY created_at %s, id %s LIMIT %d`, order, order, limit+1)
+
+	var ids []int64
+	if err := s.db.SelectContext(ctx, &ids, query, args...); err != nil {
+		return nil, false, fmt.Errorf("select ids: %w", err)
+	}
+
+	hasMore := len(ids) > limit
+	if hasMore {
+		ids = ids[:limit]
+	}
+
+	return ids, hasMore, nil
+}
+
+func (s *Repo) getMessage(ctx context.Context, q sqlx.QueryerContext, chatID, messageID int64) (*messagesdomain.Message, error) {
+	const op = "storage.postgres.getMessage"
+
+	rows, err := q.QueryxContext(ctx, messagesQuery(`
+			SELECT `+baseMessageColumns+`
+			FROM messages
+			WHERE chat_id = $1 AND id = $2
+	`), chatID, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: query: %w", op, err)
 	}
 	defer rows.Close()
 
+	msgs, err := scanMessages(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(msgs) == 0 {
+		return nil, messages.ErrMessageIsNotExist
+	}
+
+	return &msgs[0], nil
+}
+
+// scanMessages собирает сообщения из строк messagesQuery: одна строка на
+// каждую пару (вложение, вложение ответа), поэтому сообщения повторяются.
+func scanMessages(rows *sqlx.Rows) ([]messagesdomain.Message, error) {
 	messagesByID := map[int64]*messagesdomain.Message{}
 	order := make([]int64, 0)
 
@@ -313,13 +628,7 @@ func (s *Repo) GetMessages(ctx context.Context, chatID int64, limit, offset int)
 
 		if r.ReplyTo.ID.Valid {
 			if m.ReplyTo == nil {
-				m.ReplyTo = &messagesdomain.Message{
-					ID:           r.ReplyTo.ID.Int64,
-					SenderUserID: r.ReplyTo.SenderUserID.Int64,
-					Text:         r.ReplyTo.Text.String,
-					CreatedAt:    r.ReplyTo.CreatedAt.Time,
-					Attachments:  []uploadsdomain.Attachment{},
-				}
+				m.ReplyTo = messagesdomain.NewReplyFromRow(r.ReplyTo)
 			}
 
 			if r.ReplyToAttachment.ID.Valid {
@@ -347,61 +656,231 @@ func (s *Repo) GetMessages(ctx context.Context, chatID int64, limit, offset int)
 	return out, nil
 }
 
-func (s *Repo) DeleteMessage(ctx context.Context, chatID, messageID int64) error {
+// EditMessage меняет текст сообщения, сохраняя прошлую версию в message_edits.
+// Редактировать может только отправитель и только в пределах editWindow
+// (editWindow <= 0 — без ограничения).
+func (s *Repo) EditMessage(
+	ctx context.Context,
+	chatID,
+	messageID,
+	userID int64,
+	text string,
+	editWindow time.Duration,
+) (*messagesdomain.Message, error) {
 
-	const op = "storage.postgres.message.delete"
+	const op = "storage.postgres.EditMessage"
 
-	res, err := s.db.ExecContext(
-		ctx,
-		`
-		DELETE FROM messages
-		WHERE chat_id = $1 AND id = $2
-		`,
-		chatID,
-		messageID,
-	)
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: begin tx: %w", op, err)
+	}
+	defer tx.Rollback()
 
+	var current struct {
+		SenderUserID   int64     `db:"sender_user_id"`
+		Text           string    `db:"text"`
+		CreatedAt      time.Time `db:"created_at"`
+		HasAttachments bool      `db:"has_attachments"`
+	}
+	err = tx.GetContext(ctx, &current, `
+		SELECT
+			m.sender_user_id,
+			m.text,
+			m.created_at,
+			EXISTS (SELECT 1 FROM attachments a WHERE a.message_id = m.id) AS has_attachments
+		FROM messages m
+		WHERE m.chat_id = $1 AND m.id = $2 AND m.deleted_at IS NULL AND m.kind = 'user'
+		FOR UPDATE
+	`, chatID, messageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, messages.ErrMessageIsNotExist
+	}
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%s: select message: %w", op, err)
 	}
 
-	rows, err := res.RowsAffected()
+	if current.SenderUserID != userID {
+		return nil, messages.ErrNotMessageSender
+	}
+
+	if editWindow > 0 && time.Since(current.CreatedAt) > editWindow {
+		return nil, messages.ErrEditWindowExpired
+	}
 
+	if strings.TrimSpace(text) == "" && !current.HasAttachments {
+		return nil, messages.ErrTextOrAttachmentsIsRequired
+	}
+
+	if current.Text != text {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO message_edits (message_id, text)
+			VALUES ($1, $2)
+		`, messageID, current.Text); err != nil {
+			return nil, fmt.Errorf("%s: insert edit: %w", op, err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE messages
+			SET text = $1, edited_at = now()
+			WHERE id = $2
+		`, text, messageID); err != nil {
+			return nil, fmt.Errorf("%s: update message: %w", op, err)
+		}
+	}
+
+	msg, err := s.getMessage(ctx, tx, chatID, messageID)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	msgs := []messagesdomain.Message{*msg}
+	if err := attachReactions(ctx, tx, userID, msgs); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := attachForwardedChats(ctx, tx, userID, msgs); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
+	msg = &msgs[0]
 
-	if rows == 0 {
-		return messages.ErrMessageIsNotExist
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s: commit tx: %w", op, err)
 	}
 
-	return nil	
+	return msg, nil
 }
 
-func (s *Repo) DeleteMessages(ctx context.Context, chatID int64, messageIDs []int64) ([]int64, error) {
+func (s *Repo) GetMessageEdits(ctx context.Context, chatID, messageID int64) ([]messagesdomain.MessageEdit, error) {
+	const op = "storage.postgres.GetMessageEdits"
+
+	edits := []messagesdomain.MessageEdit{}
+	err := s.db.SelectContext(ctx, &edits, `
+		SELECT e.id, e.text, e.edited_at
+		FROM message_edits e
+		JOIN messages m ON m.id = e.message_id
+		WHERE m.chat_id = $1 AND e.message_id = $2 AND m.deleted_at IS NULL
+		ORDER BY e.edited_at ASC, e.id ASC
+	`, chatID, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: select: %w", op, err)
+	}
+
+	return edits, nil
+}
+
+func (s *Repo) DeleteMessage(
+	ctx context.Context,
+	chatID,
+	userID,
+	messageID int64,
+	scope messagesdomain.DeleteScope,
+	canDeleteOthers bool,
+) (bool, error) {
+	_, unpinned, err := s.DeleteMessages(ctx, chatID, userID, []int64{messageID}, scope, canDeleteOthers)
+	if errors.Is(err, messages.ErrMessagesIsNotExist) {
+		return false, messages.ErrMessageIsNotExist
+	}
+	return len(unpinned) > 0, err
+}
+
+// DeleteMessages удаляет сообщения у всех (остаётся заглушка) или скрывает
+// их только для userID. Возвращает id, к которым удаление применилось, и
+// id тех из них, с которых при удалении у всех снят закреп.
+// Удалить у всех чужое или системное сообщение можно только с canDeleteOthers.
+func (s *Repo) DeleteMessages(
+	ctx context.Context,
+	chatID,
+	userID int64,
+	messageIDs []int64,
+	scope messagesdomain.DeleteScope,
+	canDeleteOthers bool,
+) ([]int64, []int64, error) {
 
 	const op = "storage.postgres.messages.delete"
 
-	var deletedIDs []int64
-	err := s.db.SelectContext(
-		ctx,
-		&deletedIDs,
-		`
-		DELETE FROM messages
-		WHERE chat_id = $1 AND id = ANY($2)
-		RETURNING id
-		`,
-		chatID,
-		pq.Array(messageIDs),
-	)
+	if scope == messagesdomain.DeleteForMe {
+		ids, err := s.hideMessages(ctx, chatID, userID, messageIDs)
+		return ids, nil, err
+	}
 
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("%s: begin: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var targets []struct {
+		ID           int64                      `db:"id"`
+		SenderUserID int64                      `db:"sender_user_id"`
+		Kind         messagesdomain.MessageKind `db:"kind"`
+	}
+	if err := tx.SelectContext(ctx, &targets, `
+		SELECT id, sender_user_id, kind
+		FROM messages
+		WHERE chat_id = $1 AND id = ANY($2) AND deleted_at IS NULL
+		ORDER BY id
+		FOR UPDATE
+	`, chatID, pq.Array(messageIDs)); err != nil {
+		return nil, nil, fmt.Errorf("%s: select: %w", op, err)
+	}
+
+	if len(targets) == 0 {
+		return nil, nil, messages.ErrMessagesIsNotExist
+	}
+
+	ids := make([]int64, 0, len(targets))
+	for _, t := range targets {
+		own := t.SenderUserID == userID && t.Kind == messagesdomain.KindUser
+		if !own && !canDeleteOthers {
+			return nil, nil, messages.ErrDeleteNotAllowed
+		}
+		ids = append(ids, t.ID)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE messages
+		SET deleted_at = now(), deleted_by = $2
+		WHERE id = ANY($1)
+	`, pq.Array(ids), userID); err != nil {
+		return nil, nil, fmt.Errorf("%s: update: %w", op, err)
 	}
 
-	if len(deletedIDs) == 0 {
+	// заглушку удалённого сообщения закреплённой не держим
+	var unpinned []int64
+	if err := tx.SelectContext(ctx, &unpinned, `
+		DELETE FROM pinned_messages WHERE message_id = ANY($1)
+		RETURNING message_id
+	`, pq.Array(ids)); err != nil {
+		return nil, nil, fmt.Errorf("%s: unpin: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("%s: commit: %w", op, err)
+	}
+
+	return ids, unpinned, nil
+}
+
+func (s *Repo) hideMessages(ctx context.Context, chatID, userID int64, messageIDs []int64) ([]int64, error) {
+	const op = "storage.postgres.messages.hide"
+
+	var ids []int64
+	if err := s.db.SelectContext(ctx, &ids, `
+		WITH target AS (
+			SELECT id FROM messages WHERE chat_id = $1 AND id = ANY($2)
+		),
+		hidden AS (
+			INSERT INTO message_hidden (message_id, user_id)
+			SELECT id, $3 FROM target
+			ON CONFLICT DO NOTHING
+		)
+		SELECT id FROM target ORDER BY id
+	`, chatID, pq.Array(messageIDs), userID); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(ids) == 0 {
 		return nil, messages.ErrMessagesIsNotExist
 	}
 
-	return deletedIDs, nil	
-}
\ No newline at end of file
+	return ids, nil
+}
@@ -0,0 +1,176 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kgellert/hodatay-messenger/internal/messages"
+	messagesdomain "github.com/kgellert/hodatay-messenger/internal/messages"
+	"github.com/lib/pq"
+)
+
+const headlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxWords=20, MinWords=5, MaxFragments=2, FragmentDelimiter=\" … \""
+
+// escapeHTML — SQL-выражение, экранирующее HTML в expr. Сниппет отдаётся как
+// разметка, поэтому сырой текст сообщения в него попадать не должен.
+func escapeHTML(expr string) string {
+	return `replace(replace(replace(replace(replace(` + expr +
+		`, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;'), '''', '&#39;')`
+}
+
+// headline строит сниппет по doc с опциями из параметра $7. Совпадение могло
+// найтись как в русской, так и в английской конфигурации, поэтому русский
+// вариант берём, только если в нём есть подсветка.
+func headline(doc string) string {
+	escaped := escapeHTML(doc)
+	return `(
+		SELECT CASE WHEN strpos(h.ru, '<mark>') > 0 THEN h.ru ELSE h.en END
+		FROM (
+			SELECT
+				ts_headline('russian', ` + escaped + `, q.query, $7) AS ru,
+				ts_headline('english', ` + escaped + `, q.query, $7) AS en
+		) h
+	)`
+}
+
+// SearchMessages ищет по тексту сообщений и именам вложений в чатах, где
+// userID участник. Запрос разбирается websearch_to_tsquery в русской и
+// английской конфигурациях одновременно.
+func (s *Repo) SearchMessages(
+	ctx context.Context,
+	userID int64,
+	params messagesdomain.SearchMessagesParams,
+) (*messagesdomain.SearchMessagesPage, error) {
+
+	const op = "storage.postgres.SearchMessages"
+
+	var chatID sql.NullInt64
+	if params.ChatID != nil {
+		chatID = sql.NullInt64{Int64: *params.ChatID, Valid: true}
+	}
+
+	var (
+		cursorAt sql.NullTime
+		cursorID sql.NullInt64
+	)
+	if params.BeforeID != nil {
+		// Курсор ищем только среди чатов пользователя, иначе по ответу можно
+		// узнать о существовании чужих сообщений
+		var createdAt time.Time
+		err := s.db.GetContext(ctx, &createdAt, `
+			SELECT m.created_at
+			FROM messages m
+			JOIN chat_participants cp ON cp.chat_id = m.chat_id AND cp.user_id = $2
+			WHERE m.id = $1 AND ($3::bigint IS NULL OR m.chat_id = $3)
+		`, *params.BeforeID, userID, chatID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, messages.ErrMessageIsNotExist
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: select cursor: %w", op, err)
+		}
+		cursorAt = sql.NullTime{Time: createdAt, Valid: true}
+		cursorID = sql.NullInt64{Int64: *params.BeforeID, Valid: true}
+	}
+
+	var hits []struct {
+		ID      int64  `db:"id"`
+		ChatID  int64  `db:"chat_id"`
+		Snippet string `db:"snippet"`
+	}
+	err := s.db.SelectContext(ctx, &hits, `
+		WITH q AS (
+			SELECT websearch_to_tsquery('russian', $1) || websearch_to_tsquery('english', $1) AS query
+		)
+		SELECT
+			m.id,
+			m.chat_id,
+			CASE
+				WHEN m.search_tsv @@ q.query THEN `+headline("m.text")+`
+				ELSE COALESCE((
+					SELECT `+headline("a.filename")+`
+					FROM attachments a
+					WHERE a.message_id = m.id AND a.search_tsv @@ q.query
+					ORDER BY a.id
+					LIMIT 1
+				), '')
+			END AS snippet
+		FROM q, messages m
+		JOIN chat_participants cp ON cp.chat_id = m.chat_id AND cp.user_id = $2
+		WHERE ($3::bigint IS NULL OR m.chat_id = $3)
+			AND m.deleted_at IS NULL
+			AND `+notHiddenFor("$2")+`
+			AND ($4::timestamptz IS NULL OR (m.created_at, m.id) < ($4, $5))
+			AND (
+				m.search_tsv @@ q.query
+				OR EXISTS (
+					SELECT 1 FROM attachments a
+					WHERE a.message_id = m.id AND a.search_tsv @@ q.query
+				)
+			)
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT $6
+	`, params.Query, userID, chatID, cursorAt, cursorID, params.Limit+1, headlineOptions)
+	if err != nil {
+		return nil, fmt.Errorf("%s: search: %w", op, err)
+	}
+
+	page := &messagesdomain.SearchMessagesPage{Hits: []messagesdomain.SearchHit{}}
+	if len(hits) > params.Limit {
+		page.HasMore = true
+		hits = hits[:params.Limit]
+	}
+
+	if len(hits) == 0 {
+		return page, nil
+	}
+
+	ids := make([]int64, 0, len(hits))
+	for _, h := range hits {
+		ids = append(ids, h.ID)
+	}
+
+	rows, err := s.db.QueryxContext(ctx, messagesQuery(`
+			SELECT `+baseMessageColumns+`
+			FROM messages
+			WHERE id = ANY($1)
+	`), pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("%s: query messages: %w", op, err)
+	}
+	defer rows.Close()
+
+	msgs, err := scanMessages(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := attachReactions(ctx, s.db, userID, msgs); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := attachForwardedChats(ctx, s.db, userID, msgs); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	byID := make(map[int64]messagesdomain.Message, len(msgs))
+	for _, m := range msgs {
+		byID[m.ID] = m
+	}
+
+	for _, h := range hits {
+		m, ok := byID[h.ID]
+		if !ok {
+			continue
+		}
+		page.Hits = append(page.Hits, messagesdomain.SearchHit{
+			ChatID:  h.ChatID,
+			Message: m,
+			Snippet: h.Snippet,
+		})
+	}
+
+	return page, nil
+}
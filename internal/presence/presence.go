@@ -0,0 +1,207 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/kgellert/hodatay-messenger/internal/chats"
+	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
+	"github.com/kgellert/hodatay-messenger/internal/users"
+	"github.com/kgellert/hodatay-messenger/internal/ws"
+	"github.com/kgellert/hodatay-messenger/internal/ws/hub"
+)
+
+const notifyTimeout = 5 * time.Second
+
+// Service рассылает presence.online/presence.offline собеседникам пользователя
+// и сохраняет last_seen_at. Подключается к хабу через SetPresenceListener.
+// Хаб сообщает только о соединениях своего инстанса, поэтому общий статус
+// ведётся в Store: события уходят, когда пользователь появился на первом
+// инстансе или пропал с последнего.
+type Service struct {
+	hub      *hub.Hub
+	store    *Store
+	partners chats.PartnersRepo
+	users    users.Repo
+	log      *slog.Logger
+}
+
+func New(h *hub.Hub, store *Store, partners chats.PartnersRepo, usersRepo users.Repo, log *slog.Logger) *Service {
+	return &Service{hub: h, store: store, partners: partners, users: usersRepo, log: log}
+}
+
+func (s *Service) UserOnline(userID int64) {
+	// Пользователь мог уже отключиться, пока мы дошли сюда
+	if !s.hub.IsOnline(userID) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	first, err := s.store.Connect(ctx, userID)
+	if err != nil {
+		s.log.Error("failed to save presence", slog.Int64("user_id", userID), sl.Err(err))
+		return
+	}
+	if !first {
+		return
+	}
+
+	s.notify(userID, ws.PresenceOnline, ws.PresencePayload{UserID: userID})
+}
+
+func (s *Service) UserOffline(userID int64) {
+	if s.hub.IsOnline(userID) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	last, err := s.store.Disconnect(ctx, userID)
+	if err != nil {
+		s.log.Error("failed to save presence", slog.Int64("user_id", userID), sl.Err(err))
+		return
+	}
+	if !last {
+		return
+	}
+
+	s.wentOffline(userID)
+}
+
+// RunHeartbeat раз в every продлевает отметки этого инстанса, сверяет их с
+// хабом и убирает протухшие отметки упавших инстансов.
+func (s *Service) RunHeartbeat(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.heartbeat(ctx)
+		}
+	}
+}
+
+func (s *Service) heartbeat(ctx context.Context) {
+	if err := s.store.Touch(ctx, s.hub.OnlineUserIDs()); err != nil {
+		s.log.Error("failed to touch presence", sl.Err(err))
+	}
+
+	// Отметки, которые пережили отключение из-за гонки UserOnline/UserOffline
+	ids, err := s.store.InstanceUsers(ctx)
+	if err != nil {
+		s.log.Error("failed to get instance presence", sl.Err(err))
+	}
+	for _, id := range ids {
+		if !s.hub.IsOnline(id) {
+			s.UserOffline(id)
+		}
+	}
+
+	ids, err = s.store.StaleUsers(ctx)
+	if err != nil {
+		s.log.Error("failed to get stale presence", sl.Err(err))
+		return
+	}
+	for _, id := range ids {
+		last, err := s.store.Expire(ctx, id)
+		if err != nil {
+			s.log.Error("failed to expire presence", slog.Int64("user_id", id), sl.Err(err))
+			continue
+		}
+		if last {
+			s.wentOffline(id)
+		}
+	}
+}
+
+func (s *Service) wentOffline(userID int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	now := time.Now()
+	if err := s.users.SetLastSeen(ctx, userID, now); err != nil {
+		s.log.Error("failed to save last_seen_at", slog.Int64("user_id", userID), sl.Err(err))
+	}
+
+	s.notify(userID, ws.PresenceOffline, ws.PresencePayload{UserID: userID, LastSeenAt: &now})
+}
+
+func (s *Service) notify(userID int64, typ ws.EventType, payload ws.PresencePayload) {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	partnerIDs, err := s.partners.GetChatPartnerIDs(ctx, userID)
+	if err != nil {
+		s.log.Error("failed to get chat partners", slog.Int64("user_id", userID), sl.Err(err))
+		return
+	}
+
+	if len(partnerIDs) == 0 {
+		return
+	}
+
+	evt, err := ws.NewEvent(0, typ, payload)
+	if err != nil {
+		s.log.Error("failed to build presence event", sl.Err(err))
+		return
+	}
+
+	b, err := json.Marshal(evt)
+	if err != nil {
+		s.log.Error("failed to marshal presence event", sl.Err(err))
+		return
+	}
+
+	s.hub.SendToUsers(partnerIDs, b)
+}
+
+// WithOnline оборачивает users.Repo так, что GetUser/GetUsers проставляют
+// User.Online по общему статусу из Store.
+func WithOnline(repo users.Repo, store *Store) users.Repo {
+	return &onlineUsersRepo{Repo: repo, store: store}
+}
+
+type onlineUsersRepo struct {
+	users.Repo
+	store *Store
+}
+
+func (r *onlineUsersRepo) GetUser(ctx context.Context, id int64) (users.User, error) {
+	u, err := r.Repo.GetUser(ctx, id)
+	if err != nil {
+		return u, err
+	}
+
+	online, err := r.store.Online(ctx, []int64{u.ID})
+	if err != nil {
+		return u, err
+	}
+
+	u.Online = online[u.ID]
+	return u, nil
+}
+
+func (r *onlineUsersRepo) GetUsers(ctx context.Context, ids []int64) ([]users.User, error) {
+	us, err := r.Repo.GetUsers(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	online, err := r.store.Online(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range us {
+		us[i].Online = online[us[i].ID]
+	}
+	return us, nil
+}
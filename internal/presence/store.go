@@ -0,0 +1,176 @@
+package presence
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Store хранит онлайн-статус в Postgres, чтобы его видели все инстансы:
+// строка user_presence на каждый инстанс, где у пользователя есть соединения.
+// Инстанс раз в heartbeat продлевает свои строки; строки упавших инстансов
+// удаляются, когда seen_at старше ttl.
+type Store struct {
+	db         *sqlx.DB
+	instanceID string
+	ttl        time.Duration
+}
+
+func NewStore(db *sqlx.DB, instanceID string, ttl time.Duration) *Store {
+	return &Store{db: db, instanceID: instanceID, ttl: ttl}
+}
+
+// Connect отмечает пользователя онлайн на этом инстансе. first — до этого
+// он не был онлайн ни на одном.
+func (s *Store) Connect(ctx context.Context, userID int64) (bool, error) {
+	const op = "presence.Store.Connect"
+
+	before, after, err := s.update(ctx, userID, `
+		INSERT INTO user_presence (user_id, instance_id)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id, instance_id) DO UPDATE SET seen_at = now()
+	`, userID, s.instanceID)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return !before && after, nil
+}
+
+// Disconnect снимает отметку этого инстанса. last — больше пользователь не
+// онлайн нигде.
+func (s *Store) Disconnect(ctx context.Context, userID int64) (bool, error) {
+	const op = "presence.Store.Disconnect"
+
+	before, after, err := s.update(ctx, userID, `
+		DELETE FROM user_presence WHERE user_id = $1 AND instance_id = $2
+	`, userID, s.instanceID)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return before && !after, nil
+}
+
+// Expire удаляет протухшие строки пользователя. last — как у Disconnect.
+func (s *Store) Expire(ctx context.Context, userID int64) (bool, error) {
+	const op = "presence.Store.Expire"
+
+	before, after, err := s.update(ctx, userID, `
+		DELETE FROM user_presence WHERE user_id = $1 AND seen_at < $2
+	`, userID, time.Now().Add(-s.ttl))
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return before && !after, nil
+}
+
+// Touch продлевает строки этого инстанса для userIDs.
+func (s *Store) Touch(ctx context.Context, userIDs []int64) error {
+	const op = "presence.Store.Touch"
+
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE user_presence SET seen_at = now()
+		WHERE instance_id = $1 AND user_id = ANY($2)
+	`, s.instanceID, pq.Array(userIDs)); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// InstanceUsers возвращает пользователей, отмеченных онлайн на этом инстансе.
+func (s *Store) InstanceUsers(ctx context.Context) ([]int64, error) {
+	const op = "presence.Store.InstanceUsers"
+
+	var ids []int64
+	if err := s.db.SelectContext(ctx, &ids, `
+		SELECT user_id FROM user_presence WHERE instance_id = $1
+	`, s.instanceID); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ids, nil
+}
+
+// StaleUsers возвращает пользователей с протухшими строками.
+func (s *Store) StaleUsers(ctx context.Context) ([]int64, error) {
+	const op = "presence.Store.StaleUsers"
+
+	var ids []int64
+	if err := s.db.SelectContext(ctx, &ids, `
+		SELECT DISTINCT user_id FROM user_presence WHERE seen_at < $1
+	`, time.Now().Add(-s.ttl)); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ids, nil
+}
+
+// Online возвращает тех из ids, кто онлайн хотя бы на одном инстансе.
+func (s *Store) Online(ctx context.Context, ids []int64) (map[int64]bool, error) {
+	const op = "presence.Store.Online"
+
+	online := make(map[int64]bool, len(ids))
+	if len(ids) == 0 {
+		return online, nil
+	}
+
+	var rows []int64
+	if err := s.db.SelectContext(ctx, &rows, `
+		SELECT DISTINCT user_id FROM user_presence WHERE user_id = ANY($1)
+	`, pq.Array(ids)); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, id := range rows {
+		online[id] = true
+	}
+	return online, nil
+}
+
+// update выполняет query под блокировкой строки пользователя и сообщает,
+// был ли он онлайн до и после. Блокировка нужна, чтобы два инстанса не
+// разослали presence.online (или оба промолчали) при одновременном входе.
+func (s *Store) update(ctx context.Context, userID int64, query string, args ...any) (before, after bool, err error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, false, fmt.Errorf("begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var locked int
+	if err := tx.GetContext(ctx, &locked, `
+		SELECT 1 FROM users WHERE id = $1 FOR NO KEY UPDATE
+	`, userID); err != nil {
+		return false, false, fmt.Errorf("lock user: %w", err)
+	}
+
+	const existsQuery = `SELECT EXISTS (SELECT 1 FROM user_presence WHERE user_id = $1)`
+
+	if err := tx.GetContext(ctx, &before, existsQuery, userID); err != nil {
+		return false, false, fmt.Errorf("check before: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return false, false, fmt.Errorf("update: %w", err)
+	}
+
+	if err := tx.GetContext(ctx, &after, existsQuery, userID); err != nil {
+		return false, false, fmt.Errorf("check after: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, false, fmt.Errorf("commit: %w", err)
+	}
+
+	return before, after, nil
+}
@@ -0,0 +1,63 @@
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"time"
+)
+
+const tokenBytes = 32
+
+type Session struct {
+	ID        int64        `db:"id"`
+	UserID    int64        `db:"user_id"`
+	CreatedAt time.Time    `db:"created_at"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	RevokedAt sql.NullTime `db:"revoked_at"`
+}
+
+// IssuedSession is what the client gets back: the raw token is never stored,
+// only its hash.
+type IssuedSession struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type SessionResponse struct {
+	Session IssuedSession `json:"session"`
+}
+
+// NewToken генерирует случайный opaque-токен и его sha256 для хранения в БД.
+func NewToken() (token string, hash []byte, err error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+func HashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+type Repo interface {
+	CreateSession(ctx context.Context, userID int64, tokenHash []byte, expiresAt time.Time) (*Session, error)
+	GetActiveSession(ctx context.Context, tokenHash []byte) (*Session, error)
+	RotateSession(ctx context.Context, oldHash, newHash []byte, expiresAt time.Time) (*Session, error)
+	RevokeSession(ctx context.Context, tokenHash []byte) error
+	RevokeUserSessions(ctx context.Context, userID int64) (int64, error)
+}
+
+type Service interface {
+	Issue(ctx context.Context, userID int64) (*IssuedSession, error)
+	Authenticate(ctx context.Context, token string) (*Session, error)
+	Refresh(ctx context.Context, token string) (*IssuedSession, error)
+	Revoke(ctx context.Context, token string) error
+	RevokeAll(ctx context.Context, userID int64) (int64, error)
+}
@@ -0,0 +1,30 @@
+package sessions
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNewToken(t *testing.T) {
+	token, hash, err := NewToken()
+	if err != nil {
+		t.Fatalf("NewToken() failed: %v", err)
+	}
+
+	if token == "" {
+		t.Fatal("NewToken() returned empty token")
+	}
+
+	if !bytes.Equal(hash, HashToken(token)) {
+		t.Errorf("NewToken() hash does not match HashToken(token)")
+	}
+
+	other, _, err := NewToken()
+	if err != nil {
+		t.Fatalf("NewToken() failed: %v", err)
+	}
+
+	if other == token {
+		t.Errorf("NewToken() returned the same token twice")
+	}
+}
@@ -0,0 +1,11 @@
+package sessions
+
+import (
+	"errors"
+)
+
+var (
+	ErrMissingToken    = errors.New("missing session token")
+	ErrSessionNotFound = errors.New("session not found or expired")
+	ErrSignInDisabled  = errors.New("sign in by user_id is disabled")
+)
@@ -0,0 +1,153 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kgellert/hodatay-messenger/internal/sessions"
+)
+
+type Repo struct {
+	db *sqlx.DB
+}
+
+func New(db *sqlx.DB) *Repo {
+	return &Repo{db: db}
+}
+
+func (r *Repo) CreateSession(ctx context.Context, userID int64, tokenHash []byte, expiresAt time.Time) (*sessions.Session, error) {
+	const op = "storage.postgres.CreateSession"
+
+	var s sessions.Session
+	err := r.db.GetContext(
+		ctx,
+		&s,
+		`
+		INSERT INTO sessions (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)
+		RETURNING id, user_id, created_at, expires_at, revoked_at
+		`,
+		userID, tokenHash, expiresAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: insert: %w", op, err)
+	}
+
+	return &s, nil
+}
+
+// GetActiveSession находит действующую сессию. Сессии отключённого
+// пользователя не действуют, даже если их выдали уже после RevokeAll.
+func (r *Repo) GetActiveSession(ctx context.Context, tokenHash []byte) (*sessions.Session, error) {
+	const op = "storage.postgres.GetActiveSession"
+
+	var s sessions.Session
+	err := r.db.GetContext(
+		ctx,
+		&s,
+		`
+		SELECT s.id, s.user_id, s.created_at, s.expires_at, s.revoked_at
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id AND u.disabled_at IS NULL
+		WHERE s.token_hash = $1
+			AND s.revoked_at IS NULL
+			AND s.expires_at > now()
+		`,
+		tokenHash,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, sessions.ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: select: %w", op, err)
+	}
+
+	return &s, nil
+}
+
+// RotateSession заменяет токен активной сессии на новый и продлевает её.
+// Старый токен после этого перестаёт работать.
+func (r *Repo) RotateSession(ctx context.Context, oldHash, newHash []byte, expiresAt time.Time) (*sessions.Session, error) {
+	const op = "storage.postgres.RotateSession"
+
+	var s sessions.Session
+	err := r.db.GetContext(
+		ctx,
+		&s,
+		`
+		UPDATE sessions s
+		SET token_hash = $1, expires_at = $2
+		FROM users u
+		WHERE s.token_hash = $3
+			AND s.revoked_at IS NULL
+			AND s.expires_at > now()
+			AND u.id = s.user_id AND u.disabled_at IS NULL
+		RETURNING s.id, s.user_id, s.created_at, s.expires_at, s.revoked_at
+		`,
+		newHash, expiresAt, oldHash,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, sessions.ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: update: %w", op, err)
+	}
+
+	return &s, nil
+}
+
+func (r *Repo) RevokeSession(ctx context.Context, tokenHash []byte) error {
+	const op = "storage.postgres.RevokeSession"
+
+	res, err := r.db.ExecContext(
+		ctx,
+		`
+		UPDATE sessions
+		SET revoked_at = now()
+		WHERE token_hash = $1 AND revoked_at IS NULL
+		`,
+		tokenHash,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: update: %w", op, err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: rows affected: %w", op, err)
+	}
+
+	if rows == 0 {
+		return sessions.ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (r *Repo) RevokeUserSessions(ctx context.Context, userID int64) (int64, error) {
+	const op = "storage.postgres.RevokeUserSessions"
+
+	res, err := r.db.ExecContext(
+		ctx,
+		`
+		UPDATE sessions
+		SET revoked_at = now()
+		WHERE user_id = $1 AND revoked_at IS NULL
+		`,
+		userID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: update: %w", op, err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: rows affected: %w", op, err)
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,75 @@
+package sessionsservice
+
+import (
+	"context"
+	"time"
+
+	"github.com/kgellert/hodatay-messenger/internal/sessions"
+)
+
+func New(repo sessions.Repo, ttl time.Duration) sessions.Service {
+	return &service{repo: repo, ttl: ttl}
+}
+
+type service struct {
+	repo sessions.Repo
+	ttl  time.Duration
+}
+
+func (s *service) Issue(ctx context.Context, userID int64) (*sessions.IssuedSession, error) {
+	token, hash, err := sessions.NewToken()
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := s.repo.CreateSession(ctx, userID, hash, time.Now().Add(s.ttl))
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessions.IssuedSession{
+		Token:     token,
+		ExpiresAt: sess.ExpiresAt,
+	}, nil
+}
+
+func (s *service) Authenticate(ctx context.Context, token string) (*sessions.Session, error) {
+	if token == "" {
+		return nil, sessions.ErrMissingToken
+	}
+
+	return s.repo.GetActiveSession(ctx, sessions.HashToken(token))
+}
+
+func (s *service) Refresh(ctx context.Context, token string) (*sessions.IssuedSession, error) {
+	if token == "" {
+		return nil, sessions.ErrMissingToken
+	}
+
+	newToken, newHash, err := sessions.NewToken()
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := s.repo.RotateSession(ctx, sessions.HashToken(token), newHash, time.Now().Add(s.ttl))
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessions.IssuedSession{
+		Token:     newToken,
+		ExpiresAt: sess.ExpiresAt,
+	}, nil
+}
+
+func (s *service) Revoke(ctx context.Context, token string) error {
+	if token == "" {
+		return sessions.ErrMissingToken
+	}
+
+	return s.repo.RevokeSession(ctx, sessions.HashToken(token))
+}
+
+func (s *service) RevokeAll(ctx context.Context, userID int64) (int64, error) {
+	return s.repo.RevokeUserSessions(ctx, userID)
+}
@@ -1,24 +1,74 @@
+-- Пользователи
+CREATE TABLE users (
+  id BIGSERIAL PRIMARY KEY,
+  login TEXT NOT NULL UNIQUE,
+  password_hash TEXT, -- NULL: вход по паролю невозможен
+  name TEXT NOT NULL,
+  avatar_file_id TEXT,
+  is_admin BOOLEAN NOT NULL DEFAULT false,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  disabled_at TIMESTAMPTZ,
+  last_seen_at TIMESTAMPTZ
+);
+
+INSERT INTO users (login, name, is_admin) VALUES
+  ('potapov', 'Роман Потапов', true),
+  ('ivanov', 'Иван Иванов', false);
+
 -- Поручения
 CREATE TABLE matters (
   id BIGSERIAL PRIMARY KEY,
-  title TEXT,
-  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+  title TEXT NOT NULL,
+  -- closed: поручение выполнено, его чаты остаются доступны
+  status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'closed')),
+  created_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
 );
 
 -- Чаты
 CREATE TABLE chats (
   id BIGSERIAL PRIMARY KEY,
-  matter_id BIGINT NOT NULL REFERENCES matters(id) ON DELETE CASCADE,
-  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+  -- NULL — чат вне поручений (в т.ч. все личные). Поручение с чатами не удалить
+  matter_id BIGINT REFERENCES matters(id) ON DELETE RESTRICT,
+  type TEXT NOT NULL DEFAULT 'group' CHECK (type IN ('direct', 'group')),
+  -- Пара собеседников личного чата, упорядоченная: low < high
+  direct_user_low BIGINT REFERENCES users(id) ON DELETE CASCADE,
+  direct_user_high BIGINT REFERENCES users(id) ON DELETE CASCADE,
+  title TEXT,
+  description TEXT,
+  -- file_id из uploads, как users.avatar_file_id
+  avatar_file_id TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  -- Время последнего сообщения (или создания чата): по нему сортируется
+  -- список чатов, не заглядывая в messages
+  last_activity_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  -- seq последнего события чата в chat_events
+  last_event_seq BIGINT NOT NULL DEFAULT 0,
+  CHECK (
+    (type = 'direct') = (direct_user_low IS NOT NULL AND direct_user_high IS NOT NULL)
+    AND (direct_user_low IS NULL OR direct_user_low < direct_user_high)
+  )
 );
 
 CREATE INDEX idx_chats_matter_id ON chats(matter_id);
+-- Один личный чат на пару: параллельные get-or-create упираются в этот индекс
+CREATE UNIQUE INDEX idx_chats_direct_pair ON chats(direct_user_low, direct_user_high) WHERE type = 'direct';
 
 -- Чаты и участники
 CREATE TABLE chat_participants (
   chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
-  user_id BIGINT NOT NULL,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
   last_read_message_id BIGINT NOT NULL DEFAULT 0,
+  -- Права по ролям см. chats.rolePermissions
+  role TEXT NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'admin', 'member', 'peer')),
+  -- По нему выбирается новый владелец, когда старый выходит из чата
+  joined_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  -- Личные настройки участника: чат заглушён до muted_until,
+  -- закреплён вверху списка, убран в архив
+  muted_until TIMESTAMPTZ,
+  pinned_at TIMESTAMPTZ,
+  archived_at TIMESTAMPTZ,
 
   PRIMARY KEY (chat_id, user_id)
 );
@@ -32,11 +82,70 @@ CREATE TABLE messages (
   sender_user_id BIGINT NOT NULL,
   text TEXT NOT NULL,
   created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
-  reply_to_message_id BIGINT REFERENCES messages(id) ON DELETE SET NULL
+  edited_at TIMESTAMPTZ,
+  reply_to_message_id BIGINT REFERENCES messages(id) ON DELETE SET NULL,
+  -- Ключ идемпотентности от клиента: повторная отправка вернёт то же сообщение
+  client_msg_id TEXT,
+  -- "Удалить у всех": строка остаётся, клиентам отдаётся заглушка
+  deleted_at TIMESTAMPTZ,
+  deleted_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+  -- system — запись о событии в чате (кого добавили, кто вышел):
+  -- text пустой, подробности в payload, sender_user_id — кто это сделал
+  kind TEXT NOT NULL DEFAULT 'user' CHECK (kind IN ('user', 'system')),
+  payload JSONB,
+  -- Пересланное сообщение: первоисточник (автор, чат, сообщение)
+  forwarded_from_user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+  forwarded_from_chat_id BIGINT REFERENCES chats(id) ON DELETE SET NULL,
+  forwarded_from_message_id BIGINT REFERENCES messages(id) ON DELETE SET NULL,
+  -- Пишут в основном по-русски, но латиницу тоже надо находить
+  search_tsv TSVECTOR GENERATED ALWAYS AS (
+    to_tsvector('russian', text) || to_tsvector('english', text)
+  ) STORED,
+  UNIQUE (chat_id, sender_user_id, client_msg_id)
 );
 
-CREATE INDEX idx_messages_chat_created ON messages(chat_id, created_at);
+CREATE INDEX idx_messages_chat_created ON messages(chat_id, created_at, id);
 CREATE INDEX idx_messages_reply_to ON messages(reply_to_message_id) WHERE reply_to_message_id IS NOT NULL;
+CREATE INDEX idx_messages_search ON messages USING GIN (search_tsv);
+
+-- "Удалить у себя": сообщение скрыто только для user_id
+CREATE TABLE message_hidden (
+  message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  hidden_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_id, message_id)
+);
+
+-- История правок: text — версия до правки
+CREATE TABLE message_edits (
+  id BIGSERIAL PRIMARY KEY,
+  message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  text TEXT NOT NULL,
+  edited_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_message_edits_message ON message_edits(message_id, edited_at);
+
+-- Реакции
+CREATE TABLE message_reactions (
+  message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  emoji TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  PRIMARY KEY (message_id, user_id, emoji)
+);
+
+-- Закреплённые сообщения. Удаление сообщения у всех снимает закреп
+-- (storage DeleteMessages), физическое — каскадом.
+CREATE TABLE pinned_messages (
+  message_id BIGINT PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+  chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+  pinned_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+  pinned_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_pinned_messages_chat ON pinned_messages(chat_id, pinned_at DESC);
 
 -- Файлы
 CREATE TABLE attachments (
@@ -50,7 +159,12 @@ CREATE TABLE attachments (
   height INT,
   duration_ms BIGINT,
   waveform_u8 TEXT,
-  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  -- "Отчёт_за_май.pdf" -> "Отчёт за май pdf", чтобы части имени искались как слова
+  search_tsv TSVECTOR GENERATED ALWAYS AS (
+    to_tsvector('russian', regexp_replace(filename, '[._-]+', ' ', 'g'))
+      || to_tsvector('english', regexp_replace(filename, '[._-]+', ' ', 'g'))
+  ) STORED
 );
 
 ALTER TABLE attachments
@@ -63,6 +177,7 @@ CHECK (
 DROP INDEX IF EXISTS idx_attachments_file_id;
 CREATE INDEX idx_attachments_file_id ON attachments(file_id);
 CREATE INDEX idx_attachments_message_id_id ON attachments(message_id, id);
+CREATE INDEX idx_attachments_search ON attachments USING GIN (search_tsv);
 
 -- Uploads
 CREATE TABLE uploads (
@@ -85,3 +200,50 @@ CREATE TABLE uploads (
 
 CREATE INDEX idx_uploads_owner_created ON uploads(owner_user_id, created_at);
 CREATE INDEX idx_uploads_status_created ON uploads(status, created_at);
+
+
+-- Сессии
+CREATE TABLE sessions (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  token_hash BYTEA NOT NULL UNIQUE, -- sha256 от токена, сам токен не храним
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_sessions_user_active ON sessions(user_id) WHERE revoked_at IS NULL;
+
+-- Журнал WS-событий чата для догоняющих клиентов; старые записи чистятся
+CREATE TABLE chat_events (
+  chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+  seq BIGINT NOT NULL,
+  type TEXT NOT NULL,
+  data JSONB,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (chat_id, seq)
+);
+
+CREATE INDEX idx_chat_events_created ON chat_events(created_at);
+
+-- Крупные WS-события для LISTEN/NOTIFY: в NOTIFY уходит только id строки
+CREATE TABLE ws_outbox (
+  id BIGSERIAL PRIMARY KEY,
+  payload JSONB NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_ws_outbox_created ON ws_outbox(created_at);
+
+-- Онлайн-статус, общий для инстансов: строка на каждый инстанс, где у
+-- пользователя есть соединения. seen_at продлевает сам инстанс, строки
+-- упавших инстансов удаляются по таймауту
+CREATE TABLE user_presence (
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  instance_id TEXT NOT NULL,
+  seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_id, instance_id)
+);
+
+CREATE INDEX idx_user_presence_instance ON user_presence(instance_id);
+CREATE INDEX idx_user_presence_seen ON user_presence(seen_at);
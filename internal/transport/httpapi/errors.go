@@ -5,11 +5,110 @@ import (
 	"net/http"
 
 	"github.com/kgellert/hodatay-messenger/internal/chats"
+	"github.com/kgellert/hodatay-messenger/internal/matters"
 	"github.com/kgellert/hodatay-messenger/internal/messages"
+	"github.com/kgellert/hodatay-messenger/internal/sessions"
+	"github.com/kgellert/hodatay-messenger/internal/users"
 )
 
 func MapError(err error) (status int, code, msg string) {
 	switch {
+	case errors.Is(err, sessions.ErrMissingToken):
+		return http.StatusUnauthorized, "missing_session", err.Error()
+
+	case errors.Is(err, sessions.ErrSessionNotFound):
+		return http.StatusUnauthorized, "invalid_session", err.Error()
+
+	case errors.Is(err, sessions.ErrSignInDisabled):
+		return http.StatusForbidden, "sign_in_disabled", err.Error()
+
+	case errors.Is(err, users.ErrInvalidCredentials):
+		return http.StatusUnauthorized, "invalid_credentials", err.Error()
+
+	case errors.Is(err, users.ErrUserDisabled):
+		return http.StatusForbidden, "user_disabled", err.Error()
+
+	case errors.Is(err, users.ErrAdminRequired):
+		return http.StatusForbidden, "admin_required", err.Error()
+
+	case errors.Is(err, users.ErrUserNotFound):
+		return http.StatusNotFound, "user_not_found", err.Error()
+
+	case errors.Is(err, users.ErrLoginTaken):
+		return http.StatusConflict, "login_taken", err.Error()
+
+	case errors.Is(err, users.ErrLoginIsRequired):
+		return http.StatusBadRequest, "login_required", err.Error()
+
+	case errors.Is(err, users.ErrNameIsRequired):
+		return http.StatusBadRequest, "name_required", err.Error()
+
+	case errors.Is(err, users.ErrInvalidUserID):
+		return http.StatusBadRequest, "invalid_user_id", err.Error()
+
+	case errors.Is(err, chats.ErrNotChatMember):
+		return http.StatusForbidden, "not_chat_member", err.Error()
+
+	case errors.Is(err, chats.ErrPermissionDenied):
+		return http.StatusForbidden, "chat_permission_denied", err.Error()
+
+	case errors.Is(err, chats.ErrInvalidRole):
+		return http.StatusBadRequest, "invalid_role", err.Error()
+
+	case errors.Is(err, chats.ErrOwnerRoleChange):
+		return http.StatusConflict, "owner_role_change", err.Error()
+
+	case errors.Is(err, chats.ErrOwnerRemoval):
+		return http.StatusConflict, "owner_removal", err.Error()
+
+	case errors.Is(err, chats.ErrTitleTooLong):
+		return http.StatusBadRequest, "title_too_long", err.Error()
+
+	case errors.Is(err, chats.ErrDescriptionTooLong):
+		return http.StatusBadRequest, "description_too_long", err.Error()
+
+	case errors.Is(err, chats.ErrInvalidAvatar):
+		return http.StatusBadRequest, "invalid_avatar", err.Error()
+
+	case errors.Is(err, matters.ErrMatterNotFound):
+		return http.StatusNotFound, "matter_not_found", err.Error()
+
+	case errors.Is(err, matters.ErrInvalidMatterID):
+		return http.StatusBadRequest, "invalid_matter_id", err.Error()
+
+	case errors.Is(err, matters.ErrTitleIsRequired):
+		return http.StatusBadRequest, "matter_title_required", err.Error()
+
+	case errors.Is(err, matters.ErrTitleTooLong):
+		return http.StatusBadRequest, "matter_title_too_long", err.Error()
+
+	case errors.Is(err, matters.ErrInvalidStatus):
+		return http.StatusBadRequest, "invalid_matter_status", err.Error()
+
+	case errors.Is(err, matters.ErrMatterHasChats):
+		return http.StatusConflict, "matter_has_chats", err.Error()
+
+	case errors.Is(err, matters.ErrMatterClosed):
+		return http.StatusConflict, "matter_closed", err.Error()
+
+	case errors.Is(err, chats.ErrDirectChat):
+		return http.StatusConflict, "direct_chat", err.Error()
+
+	case errors.Is(err, chats.ErrInvalidDirectPeer):
+		return http.StatusBadRequest, "invalid_direct_peer", err.Error()
+
+	case errors.Is(err, chats.ErrInvalidChatsFilter):
+		return http.StatusBadRequest, "invalid_chats_filter", err.Error()
+
+	case errors.Is(err, chats.ErrInvalidChatsCursor):
+		return http.StatusBadRequest, "invalid_chats_cursor", err.Error()
+
+	case errors.Is(err, chats.ErrInvalidMutedUntil):
+		return http.StatusBadRequest, "invalid_muted_until", err.Error()
+
+	case errors.Is(err, chats.ErrInvalidChatID):
+		return http.StatusBadRequest, "invalid_chat_id", err.Error()
+
 	case errors.Is(err, chats.ErrChatNotFound):
 		return http.StatusNotFound, "chat_not_found", err.Error()
 
@@ -24,6 +123,57 @@ func MapError(err error) (status int, code, msg string) {
 
 	case errors.Is(err, messages.ErrInvalidLastReadMessageId):
 		return http.StatusBadRequest, "invalid_last_read_message_id", err.Error()
+
+	case errors.Is(err, messages.ErrInvalidLimit):
+		return http.StatusBadRequest, "invalid_limit", err.Error()
+
+	case errors.Is(err, messages.ErrInvalidCursor):
+		return http.StatusBadRequest, "invalid_cursor", err.Error()
+
+	case errors.Is(err, messages.ErrConflictingCursors):
+		return http.StatusBadRequest, "conflicting_cursors", err.Error()
+
+	case errors.Is(err, messages.ErrInvalidMessageID):
+		return http.StatusBadRequest, "invalid_message_id", err.Error()
+
+	case errors.Is(err, messages.ErrMessageIsNotExist):
+		return http.StatusNotFound, "message_not_found", err.Error()
+
+	case errors.Is(err, messages.ErrMessagesIsNotExist):
+		return http.StatusNotFound, "messages_not_found", err.Error()
+
+	case errors.Is(err, messages.ErrNotMessageSender):
+		return http.StatusForbidden, "not_message_sender", err.Error()
+
+	case errors.Is(err, messages.ErrEditWindowExpired):
+		return http.StatusForbidden, "edit_window_expired", err.Error()
+
+	case errors.Is(err, messages.ErrEmptySearchQuery):
+		return http.StatusBadRequest, "empty_search_query", err.Error()
+
+	case errors.Is(err, messages.ErrInvalidEmoji):
+		return http.StatusBadRequest, "invalid_emoji", err.Error()
+
+	case errors.Is(err, messages.ErrInvalidDeleteScope):
+		return http.StatusBadRequest, "invalid_delete_scope", err.Error()
+
+	case errors.Is(err, messages.ErrDeleteNotAllowed):
+		return http.StatusForbidden, "delete_not_allowed", err.Error()
+
+	case errors.Is(err, messages.ErrSystemMessagePin):
+		return http.StatusConflict, "system_message_pin", err.Error()
+
+	case errors.Is(err, messages.ErrInvalidForward):
+		return http.StatusBadRequest, "invalid_forward", err.Error()
+
+	case errors.Is(err, messages.ErrTooManyForwarded):
+		return http.StatusBadRequest, "too_many_forwarded", err.Error()
+
+	case errors.Is(err, messages.ErrInvalidClientMsgID):
+		return http.StatusBadRequest, "invalid_client_msg_id", err.Error()
+
+	case errors.Is(err, messages.ErrResentMessageDeleted):
+		return http.StatusConflict, "message_deleted", err.Error()
 	}
 
 	return http.StatusInternalServerError, "internal_error", "internal server error"
@@ -2,19 +2,64 @@ package users
 
 import (
 	"context"
+	"time"
+
+	"github.com/kgellert/hodatay-messenger/internal/sessions"
 )
 
 type User struct {
-	ID      int64  `json:"id"`
-	Name    string `json:"name"`
-	IsAdmin bool   `json:"is_admin"`
+	ID           int64      `json:"id" db:"id"`
+	Login        string     `json:"login" db:"login"`
+	Name         string     `json:"name" db:"name"`
+	AvatarFileID *string    `json:"avatar_file_id" db:"avatar_file_id"`
+	IsAdmin      bool       `json:"is_admin" db:"is_admin"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	DisabledAt   *time.Time `json:"disabled_at" db:"disabled_at"`
+	LastSeenAt   *time.Time `json:"last_seen_at" db:"last_seen_at"`
+	// Online заполняет presence по данным хаба, в БД его нет
+	Online bool `json:"online" db:"-"`
+}
+
+func (u User) IsDisabled() bool {
+	return u.DisabledAt != nil
+}
+
+type SignInRequest struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
 }
 
 type SignInResponse struct {
+	User    User                   `json:"user"`
+	Session sessions.IssuedSession `json:"session"`
+}
+
+type CreateUserRequest struct {
+	Login        string  `json:"login"`
+	Name         string  `json:"name"`
+	Password     *string `json:"password"`
+	AvatarFileID *string `json:"avatar_file_id"`
+	IsAdmin      bool    `json:"is_admin"`
+}
+
+// UpdateUserRequest: nil-поля не меняются, пустой avatar_file_id убирает аватар.
+type UpdateUserRequest struct {
+	Name         *string `json:"name"`
+	Password     *string `json:"password"`
+	AvatarFileID *string `json:"avatar_file_id"`
+	IsAdmin      *bool   `json:"is_admin"`
+}
+
+type UserResponse struct {
 	User User `json:"user"`
 }
 
 type Repo interface {
 	GetUser(ctx context.Context, id int64) (User, error)
 	GetUsers(ctx context.Context, ids []int64) ([]User, error)
+	GetUserByLogin(ctx context.Context, login string) (User, string, error)
+	CreateUser(ctx context.Context, req CreateUserRequest, passwordHash *string) (User, error)
+	UpdateUser(ctx context.Context, id int64, req UpdateUserRequest, passwordHash *string) (User, error)
+	DisableUser(ctx context.Context, id int64) (User, error)
+	SetLastSeen(ctx context.Context, id int64, at time.Time) error
 }
@@ -0,0 +1,16 @@
+package users
+
+import (
+	"errors"
+)
+
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserDisabled       = errors.New("user is disabled")
+	ErrLoginTaken         = errors.New("login is already taken")
+	ErrLoginIsRequired    = errors.New("login is required")
+	ErrNameIsRequired     = errors.New("name is required")
+	ErrInvalidCredentials = errors.New("invalid login or password")
+	ErrAdminRequired      = errors.New("admin rights required")
+	ErrInvalidUserID      = errors.New("invalid user_id")
+)
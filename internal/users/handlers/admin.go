@@ -0,0 +1,189 @@
+package users
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
+	"github.com/kgellert/hodatay-messenger/internal/transport/httpapi"
+	"github.com/kgellert/hodatay-messenger/internal/users"
+)
+
+// RequireAdmin пропускает дальше только активных пользователей с is_admin.
+// Должен стоять после WithUser.
+func (h *Handler) RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := h.repo.GetUser(r.Context(), UserID(r))
+		if err != nil {
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		if !user.IsAdmin || user.IsDisabled() {
+			httpapi.WriteError(w, r, users.ErrAdminRequired)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Handler) CreateUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.users.admin.create"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req users.CreateUserRequest
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			log.Error("failed to decode request", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		req.Login = strings.TrimSpace(req.Login)
+		req.Name = strings.TrimSpace(req.Name)
+
+		if req.Login == "" {
+			httpapi.WriteError(w, r, users.ErrLoginIsRequired)
+			return
+		}
+
+		if req.Name == "" {
+			httpapi.WriteError(w, r, users.ErrNameIsRequired)
+			return
+		}
+
+		passwordHash, err := hashOptionalPassword(req.Password)
+		if err != nil {
+			log.Error("failed to hash password", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		user, err := h.repo.CreateUser(r.Context(), req, passwordHash)
+		if err != nil {
+			log.Error("failed to create user", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		log.Info("user created", slog.Int64("user_id", user.ID))
+
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, users.UserResponse{
+			User: user,
+		})
+	}
+}
+
+func (h *Handler) UpdateUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.users.admin.update"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+		if err != nil || userID <= 0 {
+			log.Error("invalid user_id", slog.String("user_id", chi.URLParam(r, "userId")))
+			httpapi.WriteError(w, r, users.ErrInvalidUserID)
+			return
+		}
+
+		var req users.UpdateUserRequest
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			log.Error("failed to decode request", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		if req.Name != nil {
+			name := strings.TrimSpace(*req.Name)
+			if name == "" {
+				httpapi.WriteError(w, r, users.ErrNameIsRequired)
+				return
+			}
+			req.Name = &name
+		}
+
+		passwordHash, err := hashOptionalPassword(req.Password)
+		if err != nil {
+			log.Error("failed to hash password", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		user, err := h.repo.UpdateUser(r.Context(), userID, req, passwordHash)
+		if err != nil {
+			log.Error("failed to update user", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, users.UserResponse{
+			User: user,
+		})
+	}
+}
+
+// DisableUser помечает пользователя отключённым и отзывает все его сессии.
+func (h *Handler) DisableUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.users.admin.disable"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+		if err != nil || userID <= 0 {
+			log.Error("invalid user_id", slog.String("user_id", chi.URLParam(r, "userId")))
+			httpapi.WriteError(w, r, users.ErrInvalidUserID)
+			return
+		}
+
+		user, err := h.repo.DisableUser(r.Context(), userID)
+		if err != nil {
+			log.Error("failed to disable user", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := h.sessions.RevokeAll(r.Context(), userID); err != nil {
+			log.Error("failed to revoke sessions", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		h.conns.CloseUser(userID)
+
+		render.JSON(w, r, users.UserResponse{
+			User: user,
+		})
+	}
+}
+
+func hashOptionalPassword(password *string) (*string, error) {
+	if password == nil || *password == "" {
+		return nil, nil
+	}
+
+	hash, err := users.HashPassword(*password)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hash, nil
+}
@@ -2,44 +2,68 @@ package users
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
+	"github.com/kgellert/hodatay-messenger/internal/config"
+	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
+	"github.com/kgellert/hodatay-messenger/internal/sessions"
+	"github.com/kgellert/hodatay-messenger/internal/transport/httpapi"
 	"github.com/kgellert/hodatay-messenger/internal/users"
-	"github.com/kgellert/hodatay-messenger/internal/users/repo"
 )
 
-func New(repo *repo.Repo, log *slog.Logger) *Handler {
-	return &Handler{repo, log}
+const sessionCookie = "session"
+
+// Connections закрывает WS-соединения отозванных сессий, в том числе на
+// других инстансах.
+type Connections interface {
+	CloseSession(userID, sessionID int64)
+	CloseUser(userID int64)
+}
+
+func New(repo users.Repo, sessions sessions.Service, conns Connections, cfg config.AuthConfig, log *slog.Logger) *Handler {
+	return &Handler{repo, sessions, conns, cfg, log}
 }
 
 type Handler struct {
-	repo *repo.Repo
-	log *slog.Logger
+	repo     users.Repo
+	sessions sessions.Service
+	conns    Connections
+	cfg      config.AuthConfig
+	log      *slog.Logger
 }
 
 type userIDKeyType struct{}
+type sessionIDKeyType struct{}
+type sessionTokenKeyType struct{}
 
-var userIDKey = userIDKeyType{}
+var (
+	userIDKey       = userIDKeyType{}
+	sessionIDKey    = sessionIDKeyType{}
+	sessionTokenKey = sessionTokenKeyType{}
+)
 
-// WithUser: берёт user_id из COOKIE "user_id"
-func WithUser(next http.Handler) http.Handler {
+// WithUser: проверяет токен сессии из COOKIE "session" или заголовка
+// "Authorization: Bearer <token>" и кладёт user_id в контекст.
+func (h *Handler) WithUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		c, err := r.Cookie("user_id")
-		if err != nil || c.Value == "" {
-			http.Error(w, "missing user_id", http.StatusBadRequest)
-			return
-		}
+		token := tokenFromRequest(r)
 
-		uid, err := strconv.ParseInt(c.Value, 10, 64)
-		if err != nil || uid <= 0 {
-			http.Error(w, "invalid user_id", http.StatusBadRequest)
+		sess, err := h.sessions.Authenticate(r.Context(), token)
+		if err != nil {
+			httpapi.WriteError(w, r, err)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), userIDKey, uid)
+		ctx := context.WithValue(r.Context(), userIDKey, sess.UserID)
+		ctx = context.WithValue(ctx, sessionIDKey, sess.ID)
+		ctx = context.WithValue(ctx, sessionTokenKey, token)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -49,58 +73,205 @@ func UserID(r *http.Request) int64 {
 	return id
 }
 
-func SignIn(w http.ResponseWriter, r *http.Request) {
-	raw := r.URL.Query().Get("user_id")
-	if raw == "" {
-		http.Error(w, "missing user_id", http.StatusBadRequest)
-		return
+// SessionID — id сессии, которой авторизован запрос.
+func SessionID(r *http.Request) int64 {
+	id, _ := r.Context().Value(sessionIDKey).(int64)
+	return id
+}
+
+func sessionToken(r *http.Request) string {
+	token, _ := r.Context().Value(sessionTokenKey).(string)
+	return token
+}
+
+func tokenFromRequest(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
 	}
 
-	uid, err := strconv.ParseInt(raw, 10, 64)
-	if err != nil || uid <= 0 {
-		http.Error(w, "invalid user_id", http.StatusBadRequest)
-		return
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		return c.Value
 	}
 
+	return ""
+}
+
+func setSessionCookie(w http.ResponseWriter, s *sessions.IssuedSession) {
 	http.SetCookie(w, &http.Cookie{
-		Name:     "user_id",
-		Value:    raw,
+		Name:     sessionCookie,
+		Value:    s.Token,
 		Path:     "/",
+		Expires:  s.ExpiresAt,
 		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 	})
+}
 
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte("ok"))
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
 }
 
+// SignInHandler выдаёт сессию по логину и паролю из тела запроса.
+// Отладочный вход по ?user_id= без пароля работает только при auth.dev_sign_in.
 func (h *Handler) SignInHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		raw := r.URL.Query().Get("user_id")
-		if raw == "" {
-			http.Error(w, "missing user_id", http.StatusBadRequest)
-			return
+		const op = "handlers.users.SignIn"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var (
+			user users.User
+			err  error
+		)
+
+		if raw := r.URL.Query().Get("user_id"); raw != "" {
+			user, err = h.devSignIn(r, raw)
+		} else {
+			user, err = h.passwordSignIn(r)
 		}
 
-		uid, err := strconv.ParseInt(raw, 10, 64)
 		if err != nil {
-			http.Error(w, "invalid user_id", http.StatusBadRequest)
+			log.Warn("sign in failed", sl.Err(err))
+			httpapi.WriteError(w, r, err)
 			return
 		}
 
-		user, err := h.repo.GetUser(r.Context(), uid)
+		if user.IsDisabled() {
+			httpapi.WriteError(w, r, users.ErrUserDisabled)
+			return
+		}
+
+		sess, err := h.sessions.Issue(r.Context(), user.ID)
 		if err != nil {
-			http.Error(w, "user not found", http.StatusNotFound)
+			log.Error("failed to issue session", sl.Err(err))
+			httpapi.WriteError(w, r, err)
 			return
 		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:  "user_id",
-			Value: raw,
-			Path:  "/",
-		})
+		setSessionCookie(w, sess)
 
 		render.JSON(w, r, users.SignInResponse{
-			User: user,
+			User:    user,
+			Session: *sess,
 		})
 	}
 }
+
+func (h *Handler) devSignIn(r *http.Request, raw string) (users.User, error) {
+	if !h.cfg.DevSignIn {
+		return users.User{}, sessions.ErrSignInDisabled
+	}
+
+	uid, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || uid <= 0 {
+		return users.User{}, users.ErrInvalidUserID
+	}
+
+	return h.repo.GetUser(r.Context(), uid)
+}
+
+func (h *Handler) passwordSignIn(r *http.Request) (users.User, error) {
+	var req users.SignInRequest
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		return users.User{}, err
+	}
+
+	user, hash, err := h.repo.GetUserByLogin(r.Context(), strings.TrimSpace(req.Login))
+	if errors.Is(err, users.ErrUserNotFound) {
+		return users.User{}, users.ErrInvalidCredentials
+	}
+	if err != nil {
+		return users.User{}, err
+	}
+
+	if hash == "" || !users.CheckPassword(hash, req.Password) {
+		return users.User{}, users.ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+func (h *Handler) RefreshSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.users.RefreshSession"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		sess, err := h.sessions.Refresh(r.Context(), sessionToken(r))
+		if err != nil {
+			log.Error("failed to refresh session", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		setSessionCookie(w, sess)
+
+		render.JSON(w, r, sessions.SessionResponse{
+			Session: *sess,
+		})
+	}
+}
+
+func (h *Handler) SignOut() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.users.SignOut"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		if err := h.sessions.Revoke(r.Context(), sessionToken(r)); err != nil {
+			log.Error("failed to revoke session", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		h.conns.CloseSession(UserID(r), SessionID(r))
+
+		clearSessionCookie(w)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// SignOutAll отзывает все сессии пользователя, включая текущую.
+func (h *Handler) SignOutAll() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.users.SignOutAll"
+
+		log := h.log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		revoked, err := h.sessions.RevokeAll(r.Context(), UserID(r))
+		if err != nil {
+			log.Error("failed to revoke sessions", sl.Err(err))
+			httpapi.WriteError(w, r, err)
+			return
+		}
+
+		log.Info("sessions revoked", slog.Int64("count", revoked))
+
+		h.conns.CloseUser(UserID(r))
+
+		clearSessionCookie(w)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
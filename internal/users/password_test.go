@@ -0,0 +1,29 @@
+package users
+
+import "testing"
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("HashPassword() failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		encoded  string
+		password string
+		want     bool
+	}{
+		{name: "correct password", encoded: hash, password: "secret", want: true},
+		{name: "wrong password", encoded: hash, password: "Secret", want: false},
+		{name: "empty hash", encoded: "", password: "secret", want: false},
+		{name: "unknown scheme", encoded: "md5$1$aa$bb", password: "secret", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CheckPassword(tt.encoded, tt.password); got != tt.want {
+				t.Errorf("CheckPassword() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,19 +2,20 @@ package repo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jmoiron/sqlx"
 	usersdomain "github.com/kgellert/hodatay-messenger/internal/users"
+	"github.com/lib/pq"
 )
 
-var ErrUserNotFound = errors.New("user not found")
+const uniqueViolation = "23505"
 
-// Temporary in-memory storage until we have proper user table in DB
-var users = []usersdomain.User{
-	{ID: 1, Name: "Роман Потапов", IsAdmin: true},
-	{ID: 2, Name: "Иван Иванов", IsAdmin: false},
-}
+const userColumns = `id, login, name, avatar_file_id, is_admin, created_at, disabled_at, last_seen_at`
 
 type Repo struct {
 	db *sqlx.DB
@@ -25,17 +26,47 @@ func New(db *sqlx.DB) *Repo {
 }
 
 func (r *Repo) GetUser(ctx context.Context, id int64) (usersdomain.User, error) {
-	for i := range users {
-		if users[i].ID == id {
-			return users[i], nil
-		}
+	const op = "storage.postgres.GetUser"
+
+	var u usersdomain.User
+	err := r.db.GetContext(
+		ctx,
+		&u,
+		`SELECT `+userColumns+` FROM users WHERE id = $1`,
+		id,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return usersdomain.User{}, usersdomain.ErrUserNotFound
 	}
-	return usersdomain.User{}, ErrUserNotFound
+	if err != nil {
+		return usersdomain.User{}, fmt.Errorf("%s: select: %w", op, err)
+	}
+
+	return u, nil
 }
 
+// GetUsers достаёт пользователей одним запросом и возвращает их в порядке ids.
+// Если хотя бы одного id нет в таблице, возвращает ErrUserNotFound.
 func (r *Repo) GetUsers(ctx context.Context, ids []int64) ([]usersdomain.User, error) {
-	usersByID := make(map[int64]usersdomain.User, len(users))
-	for _, u := range users {
+	const op = "storage.postgres.GetUsers"
+
+	if len(ids) == 0 {
+		return []usersdomain.User{}, nil
+	}
+
+	var rows []usersdomain.User
+	err := r.db.SelectContext(
+		ctx,
+		&rows,
+		`SELECT `+userColumns+` FROM users WHERE id = ANY($1)`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: select: %w", op, err)
+	}
+
+	usersByID := make(map[int64]usersdomain.User, len(rows))
+	for _, u := range rows {
 		usersByID[u.ID] = u
 	}
 
@@ -43,9 +74,141 @@ func (r *Repo) GetUsers(ctx context.Context, ids []int64) ([]usersdomain.User, e
 	for _, id := range ids {
 		u, ok := usersByID[id]
 		if !ok {
-			return nil, ErrUserNotFound
+			return nil, fmt.Errorf("%s: user %d: %w", op, id, usersdomain.ErrUserNotFound)
 		}
 		result = append(result, u)
 	}
+
 	return result, nil
 }
+
+// GetUserByLogin возвращает пользователя и хеш пароля ("" если пароль не задан).
+func (r *Repo) GetUserByLogin(ctx context.Context, login string) (usersdomain.User, string, error) {
+	const op = "storage.postgres.GetUserByLogin"
+
+	var row struct {
+		usersdomain.User
+		PasswordHash sql.NullString `db:"password_hash"`
+	}
+	err := r.db.GetContext(
+		ctx,
+		&row,
+		`SELECT `+userColumns+`, password_hash FROM users WHERE login = $1`,
+		login,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return usersdomain.User{}, "", usersdomain.ErrUserNotFound
+	}
+	if err != nil {
+		return usersdomain.User{}, "", fmt.Errorf("%s: select: %w", op, err)
+	}
+
+	return row.User, row.PasswordHash.String, nil
+}
+
+func (r *Repo) CreateUser(
+	ctx context.Context,
+	req usersdomain.CreateUserRequest,
+	passwordHash *string,
+) (usersdomain.User, error) {
+	const op = "storage.postgres.CreateUser"
+
+	var u usersdomain.User
+	err := r.db.GetContext(
+		ctx,
+		&u,
+		`
+		INSERT INTO users (login, name, password_hash, avatar_file_id, is_admin)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+userColumns,
+		req.Login, req.Name, passwordHash, req.AvatarFileID, req.IsAdmin,
+	)
+	if isUniqueViolation(err) {
+		return usersdomain.User{}, usersdomain.ErrLoginTaken
+	}
+	if err != nil {
+		return usersdomain.User{}, fmt.Errorf("%s: insert: %w", op, err)
+	}
+
+	return u, nil
+}
+
+func (r *Repo) UpdateUser(
+	ctx context.Context,
+	id int64,
+	req usersdomain.UpdateUserRequest,
+	passwordHash *string,
+) (usersdomain.User, error) {
+	const op = "storage.postgres.UpdateUser"
+
+	var u usersdomain.User
+	err := r.db.GetContext(
+		ctx,
+		&u,
+		`
+		UPDATE users
+		SET name           = COALESCE($1, name),
+		    password_hash  = COALESCE($2, password_hash),
+		    avatar_file_id = NULLIF(COALESCE($3, avatar_file_id), ''),
+		    is_admin       = COALESCE($4, is_admin)
+		WHERE id = $5
+		RETURNING `+userColumns,
+		req.Name, passwordHash, req.AvatarFileID, req.IsAdmin, id,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return usersdomain.User{}, usersdomain.ErrUserNotFound
+	}
+	if err != nil {
+		return usersdomain.User{}, fmt.Errorf("%s: update: %w", op, err)
+	}
+
+	return u, nil
+}
+
+func (r *Repo) DisableUser(ctx context.Context, id int64) (usersdomain.User, error) {
+	const op = "storage.postgres.DisableUser"
+
+	var u usersdomain.User
+	err := r.db.GetContext(
+		ctx,
+		&u,
+		`
+		UPDATE users
+		SET disabled_at = COALESCE(disabled_at, now())
+		WHERE id = $1
+		RETURNING `+userColumns,
+		id,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return usersdomain.User{}, usersdomain.ErrUserNotFound
+	}
+	if err != nil {
+		return usersdomain.User{}, fmt.Errorf("%s: update: %w", op, err)
+	}
+
+	return u, nil
+}
+
+func (r *Repo) SetLastSeen(ctx context.Context, id int64, at time.Time) error {
+	const op = "storage.postgres.SetLastSeen"
+
+	_, err := r.db.ExecContext(
+		ctx,
+		`
+		UPDATE users
+		SET last_seen_at = GREATEST(COALESCE(last_seen_at, $1), $1)
+		WHERE id = $2
+		`,
+		at, id,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: update: %w", op, err)
+	}
+
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
@@ -0,0 +1,179 @@
+// Package eventlog — журнал событий чатов. Каждое событие получает номер
+// (seq) внутри чата и хранится какое-то время, чтобы переподключившийся
+// клиент мог догнать пропущенное.
+package eventlog
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kgellert/hodatay-messenger/internal/chats"
+	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
+	"github.com/kgellert/hodatay-messenger/internal/ws"
+	"github.com/kgellert/hodatay-messenger/internal/ws/hub"
+)
+
+// ErrResyncRequired — пропущенных событий больше нет в журнале (или их
+// слишком много), клиенту нужно перезагрузить чат целиком.
+var ErrResyncRequired = errors.New("event log gap, resync required")
+
+type Log struct {
+	db          *sqlx.DB
+	hub         *hub.Hub
+	retention   time.Duration
+	replayLimit int
+	log         *slog.Logger
+}
+
+func New(db *sqlx.DB, h *hub.Hub, retention time.Duration, replayLimit int, log *slog.Logger) *Log {
+	return &Log{
+		db:          db,
+		hub:         h,
+		retention:   retention,
+		replayLimit: replayLimit,
+		log:         log.With(slog.String("component", "ws/eventlog")),
+	}
+}
+
+type eventRow struct {
+	Seq  int64  `db:"seq"`
+	Type string `db:"type"`
+	Data []byte `db:"data"`
+}
+
+// Publish присваивает событию следующий seq чата, сохраняет его и рассылает
+// после коммита. Выдачу seq упорядочивает блокировка строки чата до конца
+// транзакции; рассылка идёт уже без неё, порядок по seq восстанавливает хаб.
+func (l *Log) Publish(ctx context.Context, evt ws.ServerEvent) error {
+	const op = "ws.eventlog.Publish"
+
+	tx, err := l.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: begin: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var seq int64
+	err = tx.GetContext(ctx, &seq, `
+		UPDATE chats SET last_event_seq = last_event_seq + 1
+		WHERE id = $1
+		RETURNING last_event_seq
+	`, evt.ChatID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s: %w", op, chats.ErrChatNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: next seq: %w", op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO chat_events (chat_id, seq, type, data)
+		VALUES ($1, $2, $3, $4)
+	`, evt.ChatID, seq, evt.Type, []byte(evt.Data)); err != nil {
+		return fmt.Errorf("%s: insert: %w", op, err)
+	}
+
+	evt.Seq = seq
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		return fmt.Errorf("%s: marshal: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: commit: %w", op, err)
+	}
+
+	l.hub.BroadcastSeq(evt.ChatID, seq, payload)
+
+	return nil
+}
+
+// Replay возвращает события чата с seq > sinceSeq и текущий seq чата.
+// Если часть событий уже удалена или их больше replayLimit, вместе с текущим
+// seq возвращается ErrResyncRequired.
+func (l *Log) Replay(ctx context.Context, chatID, sinceSeq int64) ([]ws.ServerEvent, int64, error) {
+	const op = "ws.eventlog.Replay"
+
+	var lastSeq int64
+	err := l.db.GetContext(ctx, &lastSeq, `SELECT last_event_seq FROM chats WHERE id = $1`, chatID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, fmt.Errorf("%s: %w", op, chats.ErrChatNotFound)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: last seq: %w", op, err)
+	}
+
+	// since_seq из будущего бывает после пересоздания БД — тоже resync
+	if sinceSeq > lastSeq || lastSeq-sinceSeq > int64(l.replayLimit) {
+		return nil, lastSeq, ErrResyncRequired
+	}
+	if sinceSeq == lastSeq {
+		return nil, lastSeq, nil
+	}
+
+	var rows []eventRow
+	if err := l.db.SelectContext(ctx, &rows, `
+		SELECT seq, type, data
+		FROM chat_events
+		WHERE chat_id = $1 AND seq > $2 AND seq <= $3
+		ORDER BY seq
+	`, chatID, sinceSeq, lastSeq); err != nil {
+		return nil, 0, fmt.Errorf("%s: select: %w", op, err)
+	}
+
+	if int64(len(rows)) != lastSeq-sinceSeq {
+		return nil, lastSeq, ErrResyncRequired
+	}
+
+	events := make([]ws.ServerEvent, 0, len(rows))
+	for _, row := range rows {
+		events = append(events, ws.ServerEvent{
+			Type:   ws.EventType(row.Type),
+			ChatID: chatID,
+			Seq:    row.Seq,
+			Data:   row.Data,
+		})
+	}
+
+	return events, lastSeq, nil
+}
+
+// RunCleanup раз в every удаляет события старше retention.
+func (l *Log) RunCleanup(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.cleanup(ctx); err != nil {
+				l.log.Error("failed to clean chat events", sl.Err(err))
+			}
+		}
+	}
+}
+
+func (l *Log) cleanup(ctx context.Context) error {
+	const op = "ws.eventlog.cleanup"
+
+	res, err := l.db.ExecContext(ctx, `
+		DELETE FROM chat_events WHERE created_at < $1
+	`, time.Now().Add(-l.retention))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n, _ := res.RowsAffected(); n > 0 {
+		l.log.Debug("chat events cleaned", slog.Int64("deleted", n))
+	}
+
+	return nil
+}
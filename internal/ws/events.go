@@ -1,6 +1,7 @@
 package ws
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 )
@@ -11,12 +12,39 @@ const (
 	MessageNew      EventType = "message.new"
 	MessageRead     EventType = "message.read"
 	MessagesDeleted EventType = "message.deleted"
+	MessageEdited   EventType = "message.edited"
+	ReactionAdded   EventType = "reaction.added"
+	ReactionRemoved EventType = "reaction.removed"
+	MessagePinned   EventType = "message.pinned"
+	MessageUnpinned EventType = "message.unpinned"
+	TypingStarted   EventType = "typing.start"
+	TypingStopped   EventType = "typing.stop"
+	PresenceOnline  EventType = "presence.online"
+	PresenceOffline EventType = "presence.offline"
+	ResyncRequired  EventType = "resync_required"
+	// Состав чата; системное сообщение об изменении приходит отдельно как message.new
+	ChatMemberAdded   EventType = "chat.member_added"
+	ChatMemberRemoved EventType = "chat.member_removed"
+	ChatUpdated       EventType = "chat.updated"
+	// Личные настройки чата; уходит только на устройства самого пользователя
+	ChatSettingsUpdated EventType = "chat.settings_updated"
+	// Ответы отправителю на message.send
+	MessageAck  EventType = "message.ack"
+	MessageNack EventType = "message.nack"
 )
 
 type ServerEvent struct {
-	Type   EventType       `json:"type"`
-	ChatID int64           `json:"chat_id"`
-	Data   json.RawMessage `json:"data,omitempty"`
+	Type   EventType `json:"type"`
+	ChatID int64     `json:"chat_id"`
+	// Seq — номер события в журнале чата, растёт монотонно в пределах чата.
+	// У эфемерных событий (typing, presence) его нет.
+	Seq  int64           `json:"seq,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Publisher сохраняет событие в журнал чата и рассылает подписчикам.
+type Publisher interface {
+	Publish(ctx context.Context, evt ServerEvent) error
 }
 
 func NewEvent(chatID int64, typ EventType, payload any) (ServerEvent, error) {
@@ -4,30 +4,50 @@ import (
 	"encoding/json"
 	"log/slog"
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/gorilla/websocket"
+	"github.com/kgellert/hodatay-messenger/internal/chats/policy"
 	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
+	"github.com/kgellert/hodatay-messenger/internal/messages"
 	userhandlers "github.com/kgellert/hodatay-messenger/internal/users/handlers"
+	"github.com/kgellert/hodatay-messenger/internal/ws/eventlog"
 	"github.com/kgellert/hodatay-messenger/internal/ws/hub"
 )
 
 type ClientMsg struct {
 	Type    string  `json:"type"`
 	ChatIDs []int64 `json:"chat_ids"`
+	ChatID  int64   `json:"chat_id"`
+	// SinceSeq: chat_id -> последний полученный seq. Для этих чатов при
+	// subscribe сначала придут пропущенные события, потом живые.
+	SinceSeq map[int64]int64 `json:"since_seq"`
+
+	// Для message.send — как тело POST /chats/{chatId}/messages;
+	// client_msg_id возвращается в message.ack/nack и защищает от дублей
+	messages.CreateMessageRequest
 }
 
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
-func WSHandler(h *hub.Hub, log *slog.Logger) http.HandlerFunc {
+func WSHandler(
+	h *hub.Hub,
+	chatsPolicy *policy.Policy,
+	events *eventlog.Log,
+	sender MessageSender,
+	log *slog.Logger,
+) http.HandlerFunc {
+	typing := newTypingTracker(h, typingTTL, log)
+
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		const op = "handlers.messages.WSHandler"
 
-		log = log.With(
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())),
 		)
@@ -41,11 +61,13 @@ func WSHandler(h *hub.Hub, log *slog.Logger) http.HandlerFunc {
 
 		userID := userhandlers.UserID(r)
 
-		hc := hub.NewConnection(conn, userID)
+		hc := hub.NewConnection(conn, userID, userhandlers.SessionID(r))
 		go hc.WritePump()
 
 		h.Register(hc)
 		defer h.Unregister(hc)
+		defer typing.StopConnection(hc)
+
 		_ = conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 		conn.SetPongHandler(func(string) error {
 			_ = conn.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -58,10 +80,24 @@ func WSHandler(h *hub.Hub, log *slog.Logger) http.HandlerFunc {
 		for {
 			_, data, err := conn.ReadMessage()
 			if err != nil {
-				log.Error("ws read error", sl.Err(err))
+				select {
+				case <-hc.Lagging():
+					log.Warn("ws connection closed as lagging", slog.Any("stats", hc.Stats()))
+				case <-hc.Revoked():
+					log.Info("ws connection closed: session revoked")
+				default:
+					log.Error("ws read error", sl.Err(err))
+				}
 				return
 			}
 
+			// Кадр мог прийти, пока WritePump закрывал соединение отозванной сессии
+			select {
+			case <-hc.Revoked():
+				return
+			default:
+			}
+
 			var msg ClientMsg
 			if err := json.Unmarshal(data, &msg); err != nil {
 				log.Error("ws bad json", sl.Err(err))
@@ -70,10 +106,75 @@ func WSHandler(h *hub.Hub, log *slog.Logger) http.HandlerFunc {
 
 			switch msg.Type {
 			case "subscribe":
-				h.Subscribe(hc, msg.ChatIDs)
+				allowed, err := chatsPolicy.MemberChats(r.Context(), userID, msg.ChatIDs)
+				if err != nil {
+					log.Error("ws subscribe policy error", sl.Err(err))
+					continue
+				}
+
+				if denied := deniedChatIDs(msg.ChatIDs, allowed); len(denied) > 0 {
+					log.Warn("ws subscribe to foreign chats denied",
+						slog.Any("requested", msg.ChatIDs),
+						slog.Any("allowed", allowed),
+					)
+					b, _ := json.Marshal(map[string]any{
+						"type":     "error",
+						"code":     "not_chat_member",
+						"chat_ids": denied,
+					})
+					hc.Send(b)
+				}
+
+				var live, replay []int64
+				for _, chatID := range allowed {
+					if _, ok := msg.SinceSeq[chatID]; ok {
+						replay = append(replay, chatID)
+					} else {
+						live = append(live, chatID)
+					}
+				}
+
+				if len(live) > 0 {
+					h.Subscribe(hc, live)
+				}
+				if len(replay) > 0 {
+					h.SubscribeReplay(hc, replay)
+					for _, chatID := range replay {
+						replayChat(r.Context(), h, events, hc, chatID, msg.SinceSeq[chatID], log)
+					}
+				}
+
+			case "typing.start", "typing.stop":
+				// Подписку хаб снимает при исключении из чата, поэтому в БД
+				// на каждый typing.* не ходим
+				if !hc.Subscribed(msg.ChatID) {
+					log.Warn("ws typing in unsubscribed chat", slog.Int64("chat_id", msg.ChatID))
+					continue
+				}
+
+				if msg.Type == "typing.start" {
+					typing.Start(hc, msg.ChatID)
+				} else {
+					typing.Stop(hc, msg.ChatID)
+				}
+
+			case "message.send":
+				sendMessage(r.Context(), chatsPolicy, sender, hc, msg, log)
+
 			default:
 				log.Info("ws unknown message type", slog.String("message type", msg.Type))
 			}
 		}
 	}
 }
+
+// deniedChatIDs возвращает запрошенные чаты не из allowed, без повторов.
+func deniedChatIDs(requested, allowed []int64) []int64 {
+	denied := []int64{}
+	for _, id := range requested {
+		if !slices.Contains(allowed, id) && !slices.Contains(denied, id) {
+			denied = append(denied, id)
+		}
+	}
+	return denied
+}
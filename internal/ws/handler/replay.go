@@ -0,0 +1,63 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
+	"github.com/kgellert/hodatay-messenger/internal/ws"
+	"github.com/kgellert/hodatay-messenger/internal/ws/eventlog"
+	"github.com/kgellert/hodatay-messenger/internal/ws/hub"
+)
+
+// replayChat догоняет соединение по журналу чата с sinceSeq. Чат уже
+// подписан через SubscribeReplay; живые события хаб отдаст после истории.
+// Если догнать нельзя, клиент получает resync_required.
+func replayChat(
+	ctx context.Context,
+	h *hub.Hub,
+	events *eventlog.Log,
+	hc *hub.Connection,
+	chatID, sinceSeq int64,
+	log *slog.Logger,
+) {
+	evts, seq, err := events.Replay(ctx, chatID, sinceSeq)
+	if err != nil {
+		if !errors.Is(err, eventlog.ErrResyncRequired) {
+			log.Error("ws replay failed", slog.Int64("chat_id", chatID), sl.Err(err))
+		}
+		h.CompleteReplay(hc, chatID, resyncEvent(chatID, seq, log), seq)
+		return
+	}
+
+	replay := make([]hub.ReplayEvent, 0, len(evts))
+	for _, evt := range evts {
+		payload, err := json.Marshal(evt)
+		if err != nil {
+			log.Error("failed to marshal ws event", sl.Err(err))
+			h.CompleteReplay(hc, chatID, resyncEvent(chatID, seq, log), seq)
+			return
+		}
+		replay = append(replay, hub.ReplayEvent{Seq: evt.Seq, Payload: payload})
+	}
+
+	h.CompleteReplay(hc, chatID, replay, seq)
+}
+
+func resyncEvent(chatID, seq int64, log *slog.Logger) []hub.ReplayEvent {
+	evt, err := ws.NewEvent(chatID, ws.ResyncRequired, ws.ResyncRequiredPayload{Seq: seq})
+	if err != nil {
+		log.Error("failed to build ws event", sl.Err(err))
+		return nil
+	}
+
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		log.Error("failed to marshal ws event", sl.Err(err))
+		return nil
+	}
+
+	return []hub.ReplayEvent{{Payload: payload}}
+}
@@ -0,0 +1,94 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/kgellert/hodatay-messenger/internal/chats"
+	"github.com/kgellert/hodatay-messenger/internal/chats/policy"
+	response "github.com/kgellert/hodatay-messenger/internal/lib"
+	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
+	"github.com/kgellert/hodatay-messenger/internal/messages"
+	"github.com/kgellert/hodatay-messenger/internal/transport/httpapi"
+	"github.com/kgellert/hodatay-messenger/internal/ws"
+	"github.com/kgellert/hodatay-messenger/internal/ws/hub"
+)
+
+// MessageSender — тот же путь отправки, что у POST /chats/{chatId}/messages.
+type MessageSender interface {
+	Send(ctx context.Context, log *slog.Logger, chatID, userID int64, req messages.CreateMessageRequest) (*messages.Message, error)
+}
+
+type MessageAck struct {
+	Type        ws.EventType `json:"type"`
+	ChatID      int64        `json:"chat_id"`
+	ClientMsgID string       `json:"client_msg_id"`
+	MessageID   int64        `json:"message_id"`
+}
+
+type MessageNack struct {
+	Type        ws.EventType       `json:"type"`
+	ChatID      int64              `json:"chat_id"`
+	ClientMsgID string             `json:"client_msg_id"`
+	Error       response.ErrorBody `json:"error"`
+}
+
+// sendMessage обрабатывает message.send и отвечает отправителю
+// message.ack или message.nack с тем же кодом ошибки, что вернул бы HTTP.
+func sendMessage(
+	ctx context.Context,
+	chatsPolicy *policy.Policy,
+	sender MessageSender,
+	hc *hub.Connection,
+	msg ClientMsg,
+	log *slog.Logger,
+) {
+	var reply any
+
+	created, err := send(ctx, chatsPolicy, sender, hc.UserID(), msg, log)
+	if err != nil {
+		log.Error("ws message.send failed", slog.Int64("chat_id", msg.ChatID), sl.Err(err))
+
+		_, code, text := httpapi.MapError(err)
+		reply = MessageNack{
+			Type:        ws.MessageNack,
+			ChatID:      msg.ChatID,
+			ClientMsgID: msg.ClientMsgID,
+			Error:       response.ErrorBody{Code: code, Message: text},
+		}
+	} else {
+		reply = MessageAck{
+			Type:        ws.MessageAck,
+			ChatID:      msg.ChatID,
+			ClientMsgID: msg.ClientMsgID,
+			MessageID:   created.ID,
+		}
+	}
+
+	b, err := json.Marshal(reply)
+	if err != nil {
+		log.Error("failed to marshal ws reply", sl.Err(err))
+		return
+	}
+	hc.Send(b)
+}
+
+func send(
+	ctx context.Context,
+	chatsPolicy *policy.Policy,
+	sender MessageSender,
+	userID int64,
+	msg ClientMsg,
+	log *slog.Logger,
+) (*messages.Message, error) {
+	if msg.ChatID <= 0 {
+		return nil, chats.ErrInvalidChatID
+	}
+
+	if err := chatsPolicy.CheckMember(ctx, msg.ChatID, userID); err != nil {
+		return nil, err
+	}
+
+	return sender.Send(ctx, log, msg.ChatID, userID, msg.CreateMessageRequest)
+}
@@ -0,0 +1,29 @@
+package ws
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/kgellert/hodatay-messenger/internal/ws/hub"
+)
+
+type StatsResponse struct {
+	Connections []hub.ConnectionStats `json:"connections"`
+}
+
+// StatsHandler отдаёт счётчики WS-соединений этого инстанса: сколько событий
+// ждёт в буфере, сколько потеряно и кого отключили как отстающих.
+func StatsHandler(h *hub.Hub, log *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.ws.Stats"
+
+		log.Debug("ws stats requested",
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		render.JSON(w, r, StatsResponse{Connections: h.Stats()})
+	}
+}
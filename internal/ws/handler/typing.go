@@ -0,0 +1,135 @@
+package ws
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
+	wsevents "github.com/kgellert/hodatay-messenger/internal/ws"
+	"github.com/kgellert/hodatay-messenger/internal/ws/hub"
+)
+
+// typingTTL: если клиент не повторил typing.start за это время, считаем что он
+// перестал печатать. Клиентам стоит слать typing.start раз в ~3 секунды.
+const typingTTL = 6 * time.Second
+
+type typingKey struct {
+	chatID int64
+	userID int64
+}
+
+type typingEntry struct {
+	conn  *hub.Connection
+	timer *time.Timer
+	gen   uint64
+}
+
+// typingTracker хранит, кто сейчас печатает, и сам рассылает typing.stop
+// по таймауту или при отключении соединения.
+type typingTracker struct {
+	mu      sync.Mutex
+	hub     *hub.Hub
+	ttl     time.Duration
+	entries map[typingKey]*typingEntry
+	gen     uint64
+	log     *slog.Logger
+}
+
+func newTypingTracker(h *hub.Hub, ttl time.Duration, log *slog.Logger) *typingTracker {
+	return &typingTracker{
+		hub:     h,
+		ttl:     ttl,
+		entries: make(map[typingKey]*typingEntry),
+		log:     log,
+	}
+}
+
+func (t *typingTracker) Start(c *hub.Connection, chatID int64) {
+	key := typingKey{chatID: chatID, userID: c.UserID()}
+
+	t.mu.Lock()
+	t.gen++
+	gen := t.gen
+	if e, ok := t.entries[key]; ok {
+		e.timer.Stop()
+	}
+	t.entries[key] = &typingEntry{
+		conn:  c,
+		gen:   gen,
+		timer: time.AfterFunc(t.ttl, func() { t.expire(key, gen) }),
+	}
+	t.mu.Unlock()
+
+	t.send(key, wsevents.TypingStarted, t.ttl)
+}
+
+func (t *typingTracker) Stop(c *hub.Connection, chatID int64) {
+	key := typingKey{chatID: chatID, userID: c.UserID()}
+
+	t.mu.Lock()
+	e, ok := t.entries[key]
+	if ok {
+		e.timer.Stop()
+		delete(t.entries, key)
+	}
+	t.mu.Unlock()
+
+	if ok {
+		t.send(key, wsevents.TypingStopped, 0)
+	}
+}
+
+// StopConnection снимает все индикаторы, выставленные через это соединение.
+func (t *typingTracker) StopConnection(c *hub.Connection) {
+	var stopped []typingKey
+
+	t.mu.Lock()
+	for key, e := range t.entries {
+		if e.conn != c {
+			continue
+		}
+		e.timer.Stop()
+		delete(t.entries, key)
+		stopped = append(stopped, key)
+	}
+	t.mu.Unlock()
+
+	for _, key := range stopped {
+		t.send(key, wsevents.TypingStopped, 0)
+	}
+}
+
+func (t *typingTracker) expire(key typingKey, gen uint64) {
+	t.mu.Lock()
+	e, ok := t.entries[key]
+	// Запись могли обновить или удалить, пока таймер срабатывал
+	if !ok || e.gen != gen {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.entries, key)
+	t.mu.Unlock()
+
+	t.send(key, wsevents.TypingStopped, 0)
+}
+
+func (t *typingTracker) send(key typingKey, typ wsevents.EventType, ttl time.Duration) {
+	evt, err := wsevents.NewEvent(key.chatID, typ, wsevents.TypingPayload{
+		UserID:      key.userID,
+		ExpiresInMs: ttl.Milliseconds(),
+	})
+	if err != nil {
+		t.log.Error("failed to build typing event", sl.Err(err))
+		return
+	}
+
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		t.log.Error("failed to marshal typing event", sl.Err(err))
+		return
+	}
+
+	t.hub.BroadcastExceptUser(key.chatID, payload, key.userID)
+}
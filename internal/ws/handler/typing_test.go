@@ -0,0 +1,145 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	wsevents "github.com/kgellert/hodatay-messenger/internal/ws"
+	"github.com/kgellert/hodatay-messenger/internal/ws/hub"
+)
+
+const testTypingTTL = 50 * time.Millisecond
+
+// recordingBackend отдаёт в events всё, что хаб публикует для других инстансов.
+type recordingBackend struct {
+	events chan wsevents.ServerEvent
+}
+
+func (b *recordingBackend) Publish(_ context.Context, e hub.Envelope) error {
+	var evt wsevents.ServerEvent
+	if err := json.Unmarshal(e.Payload, &evt); err != nil {
+		return err
+	}
+	b.events <- evt
+	return nil
+}
+
+func (b *recordingBackend) Listen(ctx context.Context, _ func(hub.Envelope)) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func newTestTracker(t *testing.T) (*typingTracker, *recordingBackend) {
+	t.Helper()
+
+	backend := &recordingBackend{events: make(chan wsevents.ServerEvent, 16)}
+	log := slog.New(slog.DiscardHandler)
+
+	h := hub.NewHub(backend, log)
+	go h.Run()
+
+	return newTypingTracker(h, testTypingTTL, log), backend
+}
+
+func waitTyping(t *testing.T, b *recordingBackend, within time.Duration) wsevents.ServerEvent {
+	t.Helper()
+	select {
+	case evt := <-b.events:
+		return evt
+	case <-time.After(within):
+		t.Fatal("timed out waiting for typing event")
+		return wsevents.ServerEvent{}
+	}
+}
+
+func expectNoTyping(t *testing.T, b *recordingBackend, within time.Duration) {
+	t.Helper()
+	select {
+	case evt := <-b.events:
+		t.Fatalf("unexpected %s event", evt.Type)
+	case <-time.After(within):
+	}
+}
+
+func TestTypingTracker_ExpiresWithoutRefresh(t *testing.T) {
+	tr, b := newTestTracker(t)
+	c := hub.NewConnection(nil, 1, 0)
+
+	tr.Start(c, 10)
+	if evt := waitTyping(t, b, time.Second); evt.Type != wsevents.TypingStarted || evt.ChatID != 10 {
+		t.Fatalf("got %s in chat %d, want typing.start in chat 10", evt.Type, evt.ChatID)
+	}
+
+	evt := waitTyping(t, b, time.Second)
+	if evt.Type != wsevents.TypingStopped {
+		t.Fatalf("got %s, want typing.stop after ttl", evt.Type)
+	}
+
+	var p wsevents.TypingPayload
+	if err := json.Unmarshal(evt.Data, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.UserID != 1 {
+		t.Errorf("typing.stop user_id = %d, want 1", p.UserID)
+	}
+
+	expectNoTyping(t, b, 2*testTypingTTL)
+}
+
+func TestTypingTracker_RefreshExtends(t *testing.T) {
+	tr, b := newTestTracker(t)
+	c := hub.NewConnection(nil, 1, 0)
+
+	tr.Start(c, 10)
+	waitTyping(t, b, time.Second)
+
+	// Повторяем start до истечения ttl: индикатор не должен погаснуть
+	for range 3 {
+		time.Sleep(testTypingTTL / 2)
+		tr.Start(c, 10)
+		if evt := waitTyping(t, b, time.Second); evt.Type != wsevents.TypingStarted {
+			t.Fatalf("got %s while refreshing, want typing.start", evt.Type)
+		}
+	}
+
+	if evt := waitTyping(t, b, time.Second); evt.Type != wsevents.TypingStopped {
+		t.Fatalf("got %s, want typing.stop after the last refresh expires", evt.Type)
+	}
+	expectNoTyping(t, b, 2*testTypingTTL)
+}
+
+func TestTypingTracker_StopConnection(t *testing.T) {
+	tr, b := newTestTracker(t)
+	gone := hub.NewConnection(nil, 1, 0)
+	other := hub.NewConnection(nil, 2, 0)
+
+	tr.Start(gone, 10)
+	tr.Start(other, 10)
+	waitTyping(t, b, time.Second)
+	waitTyping(t, b, time.Second)
+
+	tr.StopConnection(gone)
+
+	evt := waitTyping(t, b, testTypingTTL/2)
+	var p wsevents.TypingPayload
+	if err := json.Unmarshal(evt.Data, &p); err != nil {
+		t.Fatal(err)
+	}
+	if evt.Type != wsevents.TypingStopped || p.UserID != 1 {
+		t.Fatalf("got %s for user %d, want typing.stop for user 1", evt.Type, p.UserID)
+	}
+
+	// Таймер снятого индикатора не должен прислать второй typing.stop,
+	// а индикатор другого соединения гаснет сам по ttl
+	evt = waitTyping(t, b, time.Second)
+	if err := json.Unmarshal(evt.Data, &p); err != nil {
+		t.Fatal(err)
+	}
+	if evt.Type != wsevents.TypingStopped || p.UserID != 2 {
+		t.Fatalf("got %s for user %d, want typing.stop for user 2", evt.Type, p.UserID)
+	}
+	expectNoTyping(t, b, 2*testTypingTTL)
+}
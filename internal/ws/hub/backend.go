@@ -0,0 +1,79 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+type EnvelopeKind string
+
+const (
+	EnvelopeChat  EnvelopeKind = "chat"
+	EnvelopeUsers EnvelopeKind = "users"
+	// EnvelopeRevoke — закрыть соединения UserIDs (только сессии SessionID, если он задан)
+	EnvelopeRevoke EnvelopeKind = "revoke"
+)
+
+// Envelope — то, что хаб отправляет другим инстансам через Backend.
+// Origin — id хаба-отправителя: свои же конверты хаб игнорирует, потому что
+// локальным соединениям уже всё доставил. Пустой Payload у конверта чата
+// означает только отписку DropUsers, без события.
+type Envelope struct {
+	Origin      string          `json:"origin"`
+	Kind        EnvelopeKind    `json:"kind"`
+	ChatID      int64           `json:"chat_id,omitempty"`
+	Seq         int64           `json:"seq,omitempty"`
+	UserIDs     []int64         `json:"user_ids,omitempty"`
+	ExcludeUser int64           `json:"exclude_user,omitempty"`
+	DropUsers   []int64         `json:"drop_users,omitempty"`
+	SessionID   int64           `json:"session_id,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+}
+
+// Backend связывает хабы разных инстансов.
+type Backend interface {
+	// Publish отправляет конверт всем слушателям, включая отправителя.
+	Publish(ctx context.Context, e Envelope) error
+	// Listen блокируется, пока не отменят ctx или не случится ошибка,
+	// и вызывает deliver для каждого полученного конверта.
+	Listen(ctx context.Context, deliver func(Envelope)) error
+}
+
+// MemoryBackend — шина внутри одного процесса. Годится для одного инстанса
+// и для тестов, где несколько хабов изображают реплики.
+type MemoryBackend struct {
+	mu        sync.RWMutex
+	listeners map[int]func(Envelope)
+	nextID    int
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{listeners: make(map[int]func(Envelope))}
+}
+
+func (b *MemoryBackend) Publish(_ context.Context, e Envelope) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, deliver := range b.listeners {
+		deliver(e)
+	}
+	return nil
+}
+
+func (b *MemoryBackend) Listen(ctx context.Context, deliver func(Envelope)) error {
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.listeners[id] = deliver
+	b.mu.Unlock()
+
+	<-ctx.Done()
+
+	b.mu.Lock()
+	delete(b.listeners, id)
+	b.mu.Unlock()
+
+	return ctx.Err()
+}
@@ -1,66 +1,299 @@
 package hub
 
 import (
+	"context"
+	"log/slog"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
 )
 
+const (
+	publishTimeout = 5 * time.Second
+	relistenDelay  = time.Second
+
+	sendBufferSize = 128
+)
+
+// CloseLagging — код закрытия для клиента, который не успевал читать:
+// часть событий потеряна, нужно переподключиться с since_seq.
+const CloseLagging = 4008
+
+// CloseSessionRevoked — код закрытия соединения, чью сессию отозвали
+// (выход, выход везде, отключение пользователя). Переподключаться без
+// нового входа бессмысленно.
+const CloseSessionRevoked = 4001
+
 type Connection struct {
 	conn      *websocket.Conn
 	send      chan []byte
-	chatIDs   map[int64]struct{}
 	userID    int64
+	sessionID int64
 	closeOnce sync.Once
+
+	// chatIDs меняет только Run; читать снаружи — через Subscribed
+	chatsMu sync.RWMutex
+	chatIDs map[int64]struct{}
+
+	// Поля ниже трогает только Run.
+	// lastSeq — последний доставленный seq по чату, чтобы не слать дубли.
+	lastSeq map[int64]int64
+	// replaying — живые события чатов, для которых ещё идёт replay;
+	// их отправят после истории, см. SubscribeReplay.
+	replaying map[int64][]BroadcastCmd
+	// closed — соединение уже сняли с хаба и закрыли send
+	closed bool
+
+	// backlog — события из replay. Их бывает больше, чем влезает в send,
+	// поэтому они идут отдельной очередью без проверки на отставание;
+	// WritePump отправляет её раньше всего, что попало в send после неё.
+	backlogMu    sync.Mutex
+	backlog      [][]byte
+	backlogReady chan struct{}
+
+	connectedAt time.Time
+	// lagging закрывается, когда send переполнился; WritePump по нему рвёт соединение
+	lagging     chan struct{}
+	laggingOnce sync.Once
+	queued      atomic.Int64
+	dropped     atomic.Int64
+
+	// revoked закрывается, когда сессию соединения отозвали; WritePump по
+	// нему закрывает соединение с кодом CloseSessionRevoked
+	revoked     chan struct{}
+	revokedOnce sync.Once
+}
+
+// ConnectionStats — счётчики соединения для диагностики медленных клиентов.
+type ConnectionStats struct {
+	UserID      int64     `json:"user_id"`
+	ConnectedAt time.Time `json:"connected_at"`
+	// Pending — сколько событий сейчас ждёт отправки в буфере
+	Pending int `json:"pending"`
+	// Queued — сколько событий всего поставлено в буфер
+	Queued int64 `json:"queued"`
+	// Dropped — сколько событий не влезло в буфер
+	Dropped int64 `json:"dropped"`
+	Lagging bool  `json:"lagging"`
+}
+
+func (c *Connection) Stats() ConnectionStats {
+	lagging := false
+	select {
+	case <-c.lagging:
+		lagging = true
+	default:
+	}
+
+	return ConnectionStats{
+		UserID:      c.userID,
+		ConnectedAt: c.connectedAt,
+		Pending:     len(c.send),
+		Queued:      c.queued.Load(),
+		Dropped:     c.dropped.Load(),
+		Lagging:     lagging,
+	}
 }
 
 func (c *Connection) UserID() int64 { return c.userID }
 
+// Subscribed сообщает, подписано ли соединение на чат сейчас. Исключённых из
+// чата хаб отписывает сам (UnsubscribeUsers), так что это заодно проверка
+// членства без похода в БД.
+func (c *Connection) Subscribed(chatID int64) bool {
+	c.chatsMu.RLock()
+	defer c.chatsMu.RUnlock()
+	_, ok := c.chatIDs[chatID]
+	return ok
+}
+
 type SubscribeCmd struct {
 	c       *Connection
 	chatIDs []int64
+	replay  bool
+	done    chan struct{}
+}
+
+// ReplayEvent — событие из журнала чата, которое клиент пропустил.
+type ReplayEvent struct {
+	Seq     int64
+	Payload []byte
+}
+
+type ReplayCmd struct {
+	c      *Connection
+	chatID int64
+	events []ReplayEvent
+	seq    int64
+}
+
+type DirectCmd struct {
+	UserIDs []int64
+	Payload []byte
+}
+
+// RevokeCmd закрывает соединения пользователя: все или только одной сессии.
+type RevokeCmd struct {
+	UserID int64
+	// SessionID — 0, если закрыть надо все сессии пользователя
+	SessionID int64
+}
+
+// PresenceListener узнаёт о первом подключении пользователя и об отключении
+// последнего его соединения. Вызывается в отдельной горутине, поэтому к
+// моменту вызова состояние могло уже поменяться — сверяйтесь с IsOnline.
+type PresenceListener interface {
+	UserOnline(userID int64)
+	UserOffline(userID int64)
 }
 
 type BroadcastCmd struct {
-	ChatID      int64
+	ChatID int64
+	// Seq — номер события в журнале чата; 0 у эфемерных событий (typing и т.п.)
+	Seq         int64
 	Payload     []byte
 	ExcludeUser int64
+	// DropUsers — после доставки отписать соединения этих пользователей от чата.
+	// Идёт через тот же канал, что и события, поэтому порядок сохраняется.
+	DropUsers []int64
 }
 
 type Hub struct {
+	id      string
+	backend Backend
+	outbox  chan Envelope
+	log     *slog.Logger
+
 	register   chan *Connection
 	unregister chan *Connection
 	subscribe  chan SubscribeCmd
+	replay     chan ReplayCmd
 	broadcast  chan BroadcastCmd
+	direct     chan DirectCmd
+	revoke     chan RevokeCmd
+	stats      chan chan []ConnectionStats
 	chats      map[int64]map[*Connection]struct{}
+	users      map[int64]map[*Connection]struct{}
+	order      map[int64]*chatOrder
+
+	presence PresenceListener
+
+	// online дублирует len(users[id]) для чтения вне Run
+	onlineMu sync.RWMutex
+	online   map[int64]int
 }
 
-func NewConnection(conn *websocket.Conn, userID int64) *Connection {
+// NewConnection: sessionID — сессия, по которой открыто соединение; при её
+// отзыве соединение закрывается, см. CloseSession.
+func NewConnection(conn *websocket.Conn, userID, sessionID int64) *Connection {
 	return &Connection{
-		conn:    conn,
-		send:    make(chan []byte, 128),
-		chatIDs: make(map[int64]struct{}),
-		userID:  userID,
+		conn:      conn,
+		send:      make(chan []byte, sendBufferSize),
+		chatIDs:   make(map[int64]struct{}),
+		userID:    userID,
+		sessionID: sessionID,
+
+		lastSeq:   make(map[int64]int64),
+		replaying: make(map[int64][]BroadcastCmd),
+
+		backlogReady: make(chan struct{}, 1),
+
+		connectedAt: time.Now(),
+		lagging:     make(chan struct{}),
+		revoked:     make(chan struct{}),
 	}
 }
 
-func NewHub() *Hub {
+func NewHub(backend Backend, log *slog.Logger) *Hub {
 	return &Hub{
+		id:         uuid.NewString(),
+		backend:    backend,
+		outbox:     make(chan Envelope, 256),
+		log:        log.With(slog.String("component", "ws/hub")),
 		register:   make(chan *Connection, 64),
 		unregister: make(chan *Connection, 64),
 		subscribe:  make(chan SubscribeCmd, 64),
+		replay:     make(chan ReplayCmd, 64),
 		broadcast:  make(chan BroadcastCmd, 256),
+		direct:     make(chan DirectCmd, 64),
+		revoke:     make(chan RevokeCmd, 64),
+		stats:      make(chan chan []ConnectionStats),
 		chats:      make(map[int64]map[*Connection]struct{}),
+		users:      make(map[int64]map[*Connection]struct{}),
+		order:      make(map[int64]*chatOrder),
+		online:     make(map[int64]int),
+	}
+}
+
+// SetPresenceListener нужно вызвать до Run.
+func (h *Hub) SetPresenceListener(l PresenceListener) {
+	h.presence = l
+}
+
+// ID — идентификатор инстанса, уникальный на каждый запуск.
+func (h *Hub) ID() string { return h.id }
+
+// IsOnline сообщает, есть ли у пользователя соединения на этом инстансе.
+func (h *Hub) IsOnline(userID int64) bool {
+	h.onlineMu.RLock()
+	defer h.onlineMu.RUnlock()
+	return h.online[userID] > 0
+}
+
+// OnlineUserIDs — пользователи с соединениями на этом инстансе.
+func (h *Hub) OnlineUserIDs() []int64 {
+	h.onlineMu.RLock()
+	defer h.onlineMu.RUnlock()
+
+	ids := make([]int64, 0, len(h.online))
+	for id := range h.online {
+		ids = append(ids, id)
 	}
+	return ids
 }
 
 func (h *Hub) Run() {
+	go h.publishLoop()
+	go h.listenLoop()
+
+	reorder := time.NewTicker(reorderWait / 4)
+	defer reorder.Stop()
+
 	for {
 		select {
 		case c := <-h.register:
-			_ = c
+			conns := h.users[c.userID]
+			if conns == nil {
+				conns = make(map[*Connection]struct{})
+				h.users[c.userID] = conns
+			}
+			conns[c] = struct{}{}
+			h.setOnline(c.userID, len(conns))
+
+			if len(conns) == 1 && h.presence != nil {
+				go h.presence.UserOnline(c.userID)
+			}
 
 		case c := <-h.unregister:
+			if conns := h.users[c.userID]; conns != nil {
+				if _, ok := conns[c]; ok {
+					delete(conns, c)
+					h.setOnline(c.userID, len(conns))
+
+					if len(conns) == 0 {
+						delete(h.users, c.userID)
+						if h.presence != nil {
+							go h.presence.UserOffline(c.userID)
+						}
+					}
+				}
+			}
+
 			for chatID := range c.chatIDs {
 				room := h.chats[chatID]
 				if room == nil {
@@ -68,36 +301,126 @@ func (h *Hub) Run() {
 				}
 				delete(room, c)
 				if len(room) == 0 {
-					delete(h.chats, chatID)
+					h.dropRoom(chatID)
 				}
 			}
+			c.closed = true
 			c.CloseSend()
 
 		case cmd := <-h.subscribe:
-			for _, chatID := range cmd.chatIDs {
-				room := h.chats[chatID]
-				if room == nil {
-					room = make(map[*Connection]struct{})
-					h.chats[chatID] = room
+			// Соединение могло успеть отключиться, пока команда шла по каналу
+			if !cmd.c.closed {
+				for _, chatID := range cmd.chatIDs {
+					room := h.chats[chatID]
+					if room == nil {
+						room = make(map[*Connection]struct{})
+						h.chats[chatID] = room
+					}
+					room[cmd.c] = struct{}{}
+					cmd.c.chatsMu.Lock()
+					cmd.c.chatIDs[chatID] = struct{}{}
+					cmd.c.chatsMu.Unlock()
+
+					if cmd.replay {
+						cmd.c.replaying[chatID] = nil
+					}
 				}
-				room[cmd.c] = struct{}{}
-				cmd.c.chatIDs[chatID] = struct{}{}
+			}
+			if cmd.done != nil {
+				close(cmd.done)
 			}
 
-		case b := <-h.broadcast:
-			room := h.chats[b.ChatID]
-			if room == nil {
+		case cmd := <-h.replay:
+			c := cmd.c
+			if c.closed {
 				continue
 			}
 
-			for c := range room {
-				if b.ExcludeUser != 0 && c.userID == b.ExcludeUser {
-					continue
+			pending := c.replaying[cmd.chatID]
+			delete(c.replaying, cmd.chatID)
+
+			for _, e := range cmd.events {
+				c.deliverReplayed(cmd.chatID, e)
+			}
+			if c.lastSeq[cmd.chatID] < cmd.seq {
+				c.lastSeq[cmd.chatID] = cmd.seq
+			}
+			for _, b := range pending {
+				c.deliver(b)
+			}
+
+		case b := <-h.broadcast:
+			h.route(b)
+
+		case now := <-reorder.C:
+			h.flushStale(now)
+
+		case d := <-h.direct:
+			for _, userID := range d.UserIDs {
+				for c := range h.users[userID] {
+					c.Send(d.Payload)
+				}
+			}
+
+		case cmd := <-h.revoke:
+			for c := range h.users[cmd.UserID] {
+				if cmd.SessionID == 0 || c.sessionID == cmd.SessionID {
+					c.revoke()
 				}
-				c.Send(b.Payload)
 			}
+
+		case reply := <-h.stats:
+			stats := []ConnectionStats{}
+			for _, conns := range h.users {
+				for c := range conns {
+					stats = append(stats, c.Stats())
+				}
+			}
+			reply <- stats
+		}
+	}
+}
+
+// deliverRoom выполняет команду для подписчиков чата на этом инстансе.
+func (h *Hub) deliverRoom(b BroadcastCmd) {
+	room := h.chats[b.ChatID]
+	if room == nil {
+		return
+	}
+
+	for c := range room {
+		if b.ExcludeUser != 0 && c.userID == b.ExcludeUser {
+			continue
+		}
+		if len(b.Payload) > 0 {
+			c.deliver(b)
+		}
+		if slices.Contains(b.DropUsers, c.userID) {
+			delete(room, c)
+			c.forget(b.ChatID)
 		}
 	}
+	if len(room) == 0 {
+		h.dropRoom(b.ChatID)
+	}
+}
+
+func (h *Hub) setOnline(userID int64, conns int) {
+	h.onlineMu.Lock()
+	defer h.onlineMu.Unlock()
+
+	if conns == 0 {
+		delete(h.online, userID)
+		return
+	}
+	h.online[userID] = conns
+}
+
+// Stats возвращает счётчики всех соединений этого инстанса.
+func (h *Hub) Stats() []ConnectionStats {
+	reply := make(chan []ConnectionStats, 1)
+	h.stats <- reply
+	return <-reply
 }
 
 func (h *Hub) Register(c *Connection) {
@@ -108,35 +431,274 @@ func (h *Hub) Unregister(c *Connection) {
 	h.unregister <- c
 }
 
+// Subscribe возвращается, когда подписка уже действует: сразу после него
+// Subscribed видит новые чаты.
 func (h *Hub) Subscribe(c *Connection, chatIDs []int64) {
+	done := make(chan struct{})
 	h.subscribe <- SubscribeCmd{
 		c:       c,
 		chatIDs: chatIDs,
+		done:    done,
+	}
+	<-done
+}
+
+// SubscribeReplay подписывает соединение на чаты, но придерживает их живые
+// события до CompleteReplay. Возвращается, когда подписка уже действует, так
+// что историю после этого можно читать из БД без дыр.
+func (h *Hub) SubscribeReplay(c *Connection, chatIDs []int64) {
+	done := make(chan struct{})
+	h.subscribe <- SubscribeCmd{
+		c:       c,
+		chatIDs: chatIDs,
+		replay:  true,
+		done:    done,
+	}
+	<-done
+}
+
+// CompleteReplay отправляет пропущенные события чата, затем придержанные
+// живые. seq — номер, начиная с которого клиент в курсе (после resync это
+// текущий seq чата); живые события не новее него отбрасываются.
+func (h *Hub) CompleteReplay(c *Connection, chatID int64, events []ReplayEvent, seq int64) {
+	h.replay <- ReplayCmd{
+		c:      c,
+		chatID: chatID,
+		events: events,
+		seq:    seq,
 	}
 }
 
+// Broadcast доставляет payload подписчикам чата на этом инстансе и
+// публикует его через Backend для остальных.
 func (h *Hub) Broadcast(chatID int64, payload []byte) {
-	h.broadcast <- BroadcastCmd{
-		ChatID:  chatID,
+	h.BroadcastExceptUser(chatID, payload, 0)
+}
+
+// BroadcastSeq — Broadcast для события из журнала чата с номером seq.
+// События чата публикуют разные инстансы независимо, поэтому порядок по seq
+// восстанавливает хаб-получатель, см. chatOrder.
+func (h *Hub) BroadcastSeq(chatID, seq int64, payload []byte) {
+	h.send(BroadcastCmd{ChatID: chatID, Seq: seq, Payload: payload})
+}
+
+// UnsubscribeUsers отписывает все соединения пользователей от чата на всех
+// инстансах — после событий, разосланных раньше.
+func (h *Hub) UnsubscribeUsers(chatID int64, userIDs []int64) {
+	h.send(BroadcastCmd{ChatID: chatID, DropUsers: userIDs})
+}
+
+func (h *Hub) BroadcastExceptUser(chatID int64, payload []byte, excludeUserID int64) {
+	h.send(BroadcastCmd{ChatID: chatID, Payload: payload, ExcludeUser: excludeUserID})
+}
+
+func (h *Hub) send(b BroadcastCmd) {
+	h.broadcast <- b
+	h.publish(h.envelope(b))
+}
+
+func (h *Hub) envelope(b BroadcastCmd) Envelope {
+	return Envelope{
+		Origin:      h.id,
+		Kind:        EnvelopeChat,
+		ChatID:      b.ChatID,
+		Seq:         b.Seq,
+		ExcludeUser: b.ExcludeUser,
+		DropUsers:   b.DropUsers,
+		Payload:     b.Payload,
+	}
+}
+
+// SendToUsers отправляет payload во все соединения указанных пользователей,
+// независимо от подписок на чаты.
+func (h *Hub) SendToUsers(userIDs []int64, payload []byte) {
+	h.direct <- DirectCmd{
+		UserIDs: userIDs,
 		Payload: payload,
 	}
+	h.publish(Envelope{
+		Kind:    EnvelopeUsers,
+		UserIDs: userIDs,
+		Payload: payload,
+	})
 }
 
-func (h *Hub) BroadcastExceptUser(chatID int64, payload []byte, excludeUserID int64) {
-	h.broadcast <- BroadcastCmd{
-		ChatID:      chatID,
-		Payload:     payload,
-		ExcludeUser: excludeUserID,
+// CloseSession закрывает соединения отозванной сессии пользователя на всех
+// инстансах.
+func (h *Hub) CloseSession(userID, sessionID int64) {
+	h.closeSessions(RevokeCmd{UserID: userID, SessionID: sessionID})
+}
+
+// CloseUser закрывает все соединения пользователя на всех инстансах — после
+// отзыва всех его сессий.
+func (h *Hub) CloseUser(userID int64) {
+	h.closeSessions(RevokeCmd{UserID: userID})
+}
+
+func (h *Hub) closeSessions(cmd RevokeCmd) {
+	h.revoke <- cmd
+	h.publish(Envelope{
+		Kind:      EnvelopeRevoke,
+		UserIDs:   []int64{cmd.UserID},
+		SessionID: cmd.SessionID,
+	})
+}
+
+func (h *Hub) publish(e Envelope) {
+	e.Origin = h.id
+
+	select {
+	case h.outbox <- e:
+	default:
+		h.log.Warn("ws outbox is full, event is not published to other instances",
+			slog.String("kind", string(e.Kind)),
+			slog.Int64("chat_id", e.ChatID),
+		)
 	}
 }
 
+// publishLoop отправляет конверты по одному, чтобы сохранить порядок событий.
+func (h *Hub) publishLoop() {
+	for e := range h.outbox {
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		if err := h.backend.Publish(ctx, e); err != nil {
+			h.log.Error("failed to publish ws event", sl.Err(err))
+		}
+		cancel()
+	}
+}
+
+func (h *Hub) listenLoop() {
+	for {
+		if err := h.backend.Listen(context.Background(), h.deliverRemote); err != nil {
+			h.log.Error("ws backend listener stopped, restarting", sl.Err(err))
+		}
+		time.Sleep(relistenDelay)
+	}
+}
+
+func (h *Hub) deliverRemote(e Envelope) {
+	if e.Origin == h.id {
+		return
+	}
+
+	switch e.Kind {
+	case EnvelopeChat:
+		h.broadcast <- BroadcastCmd{
+			ChatID:      e.ChatID,
+			Seq:         e.Seq,
+			Payload:     e.Payload,
+			ExcludeUser: e.ExcludeUser,
+			DropUsers:   e.DropUsers,
+		}
+	case EnvelopeUsers:
+		h.direct <- DirectCmd{
+			UserIDs: e.UserIDs,
+			Payload: e.Payload,
+		}
+	case EnvelopeRevoke:
+		for _, userID := range e.UserIDs {
+			h.revoke <- RevokeCmd{UserID: userID, SessionID: e.SessionID}
+		}
+	default:
+		h.log.Warn("unknown ws envelope kind", slog.String("kind", string(e.Kind)))
+	}
+}
+
+func (c *Connection) forget(chatID int64) {
+	c.chatsMu.Lock()
+	delete(c.chatIDs, chatID)
+	c.chatsMu.Unlock()
+	delete(c.lastSeq, chatID)
+	delete(c.replaying, chatID)
+}
+
+// deliver отправляет событие чата с учётом seq: во время replay придерживает,
+// уже доставленные номера пропускает.
+func (c *Connection) deliver(b BroadcastCmd) {
+	if b.Seq == 0 {
+		c.Send(b.Payload)
+		return
+	}
+
+	if pending, ok := c.replaying[b.ChatID]; ok {
+		c.replaying[b.ChatID] = append(pending, b)
+		return
+	}
+
+	if b.Seq <= c.lastSeq[b.ChatID] {
+		return
+	}
+	c.lastSeq[b.ChatID] = b.Seq
+	c.Send(b.Payload)
+}
+
+// deliverReplayed — deliver для события из replay: оно идёт в backlog, а не
+// в send, чтобы длинная история не переполнила буфер.
+func (c *Connection) deliverReplayed(chatID int64, e ReplayEvent) {
+	if e.Seq != 0 {
+		if e.Seq <= c.lastSeq[chatID] {
+			return
+		}
+		c.lastSeq[chatID] = e.Seq
+	}
+
+	c.backlogMu.Lock()
+	c.backlog = append(c.backlog, e.Payload)
+	c.backlogMu.Unlock()
+
+	select {
+	case c.backlogReady <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Connection) takeBacklog() [][]byte {
+	c.backlogMu.Lock()
+	defer c.backlogMu.Unlock()
+
+	b := c.backlog
+	c.backlog = nil
+	return b
+}
+
+// Send ставит сообщение в буфер соединения. Если буфер полон, клиент
+// считается отставшим: событие и все следующие отбрасываются, а WritePump
+// закрывает соединение с кодом CloseLagging.
 func (c *Connection) Send(b []byte) {
+	select {
+	case <-c.lagging:
+		c.dropped.Add(1)
+		return
+	case <-c.revoked:
+		c.dropped.Add(1)
+		return
+	default:
+	}
+
 	select {
 	case c.send <- b:
+		c.queued.Add(1)
 	default:
+		c.dropped.Add(1)
+		c.laggingOnce.Do(func() { close(c.lagging) })
 	}
 }
 
+// Lagging закрыт, если соединение не успевало читать и будет закрыто.
+func (c *Connection) Lagging() <-chan struct{} {
+	return c.lagging
+}
+
+// Revoked закрыт, если сессию соединения отозвали и оно будет закрыто.
+func (c *Connection) Revoked() <-chan struct{} {
+	return c.revoked
+}
+
+func (c *Connection) revoke() {
+	c.revokedOnce.Do(func() { close(c.revoked) })
+}
+
 func (c *Connection) CloseSend() {
 	c.closeOnce.Do(func() {
 		close(c.send)
@@ -0,0 +1,484 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type presenceRecorder struct {
+	events chan string
+}
+
+func (p *presenceRecorder) UserOnline(userID int64)  { p.events <- "online" }
+func (p *presenceRecorder) UserOffline(userID int64) { p.events <- "offline" }
+
+func waitEvent(t *testing.T, events chan string) string {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for presence event")
+		return ""
+	}
+}
+
+func TestHub_Presence(t *testing.T) {
+	rec := &presenceRecorder{events: make(chan string, 8)}
+
+	h := NewHub(NewMemoryBackend(), slog.New(slog.DiscardHandler))
+	h.SetPresenceListener(rec)
+	go h.Run()
+
+	c1 := NewConnection(nil, 1, 0)
+	c2 := NewConnection(nil, 1, 0)
+
+	h.Register(c1)
+	if got := waitEvent(t, rec.events); got != "online" {
+		t.Fatalf("first connection: got %q, want online", got)
+	}
+
+	h.Register(c2)
+	h.Unregister(c1)
+
+	select {
+	case e := <-rec.events:
+		t.Fatalf("second connection changed presence: %q", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if !h.IsOnline(1) {
+		t.Error("IsOnline() = false with one connection left")
+	}
+
+	h.Unregister(c2)
+	if got := waitEvent(t, rec.events); got != "offline" {
+		t.Fatalf("last connection: got %q, want offline", got)
+	}
+
+	if h.IsOnline(1) {
+		t.Error("IsOnline() = true after all connections closed")
+	}
+}
+
+func waitListeners(t *testing.T, b *MemoryBackend, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		b.mu.RLock()
+		got := len(b.listeners)
+		b.mu.RUnlock()
+		if got >= n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("backend listeners: want %d", n)
+}
+
+func waitPayload(t *testing.T, c *Connection) string {
+	t.Helper()
+	select {
+	case p := <-c.send:
+		return string(p)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for payload")
+		return ""
+	}
+}
+
+func TestHub_BroadcastAcrossInstances(t *testing.T) {
+	backend := NewMemoryBackend()
+	log := slog.New(slog.DiscardHandler)
+
+	a := NewHub(backend, log)
+	b := NewHub(backend, log)
+	go a.Run()
+	go b.Run()
+	waitListeners(t, backend, 2)
+
+	ca := NewConnection(nil, 1, 0)
+	cb := NewConnection(nil, 2, 0)
+	a.Register(ca)
+	b.Register(cb)
+	a.Subscribe(ca, []int64{10})
+	b.Subscribe(cb, []int64{10})
+	time.Sleep(20 * time.Millisecond)
+
+	a.Broadcast(10, []byte("hello"))
+
+	if got := waitPayload(t, ca); got != "hello" {
+		t.Fatalf("local connection: got %q", got)
+	}
+	if got := waitPayload(t, cb); got != "hello" {
+		t.Fatalf("remote connection: got %q", got)
+	}
+
+	// Собственное событие не должно вернуться из шины второй раз
+	select {
+	case p := <-ca.send:
+		t.Fatalf("local connection got duplicate %q", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// frameReader читает сообщения соединения в том порядке, в каком их
+// отправил бы WritePump: backlog раньше того, что пришло в send после него.
+type frameReader struct {
+	c    *Connection
+	held [][]byte
+}
+
+func (r *frameReader) next(t *testing.T) string {
+	t.Helper()
+	deadline := time.After(time.Second)
+	for {
+		if b := r.c.takeBacklog(); len(b) > 0 {
+			r.held = append(b, r.held...)
+		}
+		if len(r.held) > 0 {
+			p := r.held[0]
+			r.held = r.held[1:]
+			return string(p)
+		}
+
+		select {
+		case p := <-r.c.send:
+			r.held = append(r.held, p)
+		case <-r.c.backlogReady:
+		case <-deadline:
+			t.Fatal("timed out waiting for frame")
+			return ""
+		}
+	}
+}
+
+func TestHub_ReplayBeforeLive(t *testing.T) {
+	h := NewHub(NewMemoryBackend(), slog.New(slog.DiscardHandler))
+	go h.Run()
+
+	c := NewConnection(nil, 1, 0)
+	h.Register(c)
+	h.SubscribeReplay(c, []int64{10})
+
+	// Живые события во время replay придерживаются
+	h.BroadcastSeq(10, 2, []byte("2"))
+	h.BroadcastSeq(10, 3, []byte("3"))
+	time.Sleep(20 * time.Millisecond)
+
+	select {
+	case p := <-c.send:
+		t.Fatalf("live event %q sent before replay completed", p)
+	default:
+	}
+
+	h.CompleteReplay(c, 10, []ReplayEvent{
+		{Seq: 1, Payload: []byte("1")},
+		{Seq: 2, Payload: []byte("2")},
+	}, 2)
+
+	frames := &frameReader{c: c}
+	for _, want := range []string{"1", "2", "3"} {
+		if got := frames.next(t); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+
+	select {
+	case p := <-c.send:
+		t.Fatalf("duplicate event %q", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if b := c.takeBacklog(); len(b) > 0 {
+		t.Fatalf("duplicate replayed events %q", b)
+	}
+}
+
+func TestHub_LongReplayDoesNotLag(t *testing.T) {
+	h := NewHub(NewMemoryBackend(), slog.New(slog.DiscardHandler))
+	go h.Run()
+
+	c := NewConnection(nil, 1, 0)
+	h.Register(c)
+	h.SubscribeReplay(c, []int64{10})
+
+	const n = 3 * sendBufferSize
+	events := make([]ReplayEvent, n)
+	for i := range events {
+		events[i] = ReplayEvent{Seq: int64(i + 1), Payload: []byte(strconv.Itoa(i + 1))}
+	}
+	h.CompleteReplay(c, 10, events, n)
+	h.BroadcastSeq(10, n+1, []byte(strconv.Itoa(n+1)))
+
+	frames := &frameReader{c: c}
+	for i := 1; i <= n+1; i++ {
+		if got := frames.next(t); got != strconv.Itoa(i) {
+			t.Fatalf("got %q, want %d", got, i)
+		}
+	}
+
+	if st := c.Stats(); st.Lagging || st.Dropped != 0 {
+		t.Fatalf("replay marked connection lagging: %+v", st)
+	}
+}
+
+func TestConnection_SendOverflowMarksLagging(t *testing.T) {
+	c := NewConnection(nil, 1, 0)
+
+	for range sendBufferSize {
+		c.Send([]byte("x"))
+	}
+
+	select {
+	case <-c.Lagging():
+		t.Fatal("connection is lagging before buffer overflow")
+	default:
+	}
+
+	c.Send([]byte("overflow"))
+	c.Send([]byte("after"))
+
+	select {
+	case <-c.Lagging():
+	default:
+		t.Fatal("connection is not lagging after buffer overflow")
+	}
+
+	st := c.Stats()
+	if st.Queued != sendBufferSize || st.Dropped != 2 || st.Pending != sendBufferSize || !st.Lagging {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestHub_UnsubscribeUsersAfterEvent(t *testing.T) {
+	h := NewHub(NewMemoryBackend(), slog.New(slog.DiscardHandler))
+	go h.Run()
+
+	removed := NewConnection(nil, 1, 0)
+	other := NewConnection(nil, 2, 0)
+	h.Register(removed)
+	h.Register(other)
+	h.Subscribe(removed, []int64{10})
+	h.Subscribe(other, []int64{10})
+	if !removed.Subscribed(10) {
+		t.Fatal("Subscribed() = false right after Subscribe")
+	}
+
+	// Событие об исключении ещё доходит до исключённого, следующие — нет
+	h.Broadcast(10, []byte("removed"))
+	h.UnsubscribeUsers(10, []int64{1})
+	h.Broadcast(10, []byte("after"))
+
+	if got := waitPayload(t, removed); got != "removed" {
+		t.Fatalf("removed user: got %q", got)
+	}
+	for _, want := range []string{"removed", "after"} {
+		if got := waitPayload(t, other); got != want {
+			t.Fatalf("other user: got %q, want %q", got, want)
+		}
+	}
+
+	select {
+	case p := <-removed.send:
+		t.Fatalf("removed user got %q after unsubscribe", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// По Subscribed ws-хендлер не пускает typing.* от исключённых
+	if removed.Subscribed(10) {
+		t.Error("Subscribed() = true after UnsubscribeUsers")
+	}
+}
+
+// jsonBackend прогоняет конверты через JSON, как настоящая шина между
+// инстансами.
+type jsonBackend struct {
+	*MemoryBackend
+}
+
+func (b jsonBackend) Publish(ctx context.Context, e Envelope) error {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	var decoded Envelope
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return err
+	}
+	return b.MemoryBackend.Publish(ctx, decoded)
+}
+
+func TestHub_UnsubscribeUsersAcrossInstances(t *testing.T) {
+	mem := NewMemoryBackend()
+	backend := jsonBackend{mem}
+	log := slog.New(slog.DiscardHandler)
+
+	a := NewHub(backend, log)
+	b := NewHub(backend, log)
+	go a.Run()
+	go b.Run()
+	waitListeners(t, mem, 2)
+
+	removed := NewConnection(nil, 1, 0)
+	other := NewConnection(nil, 2, 0)
+	b.Register(removed)
+	b.Register(other)
+	b.Subscribe(removed, []int64{10})
+	b.Subscribe(other, []int64{10})
+	time.Sleep(20 * time.Millisecond)
+
+	a.Broadcast(10, []byte(`"removed"`))
+	a.UnsubscribeUsers(10, []int64{1})
+	a.Broadcast(10, []byte(`"after"`))
+
+	if got := waitPayload(t, removed); got != `"removed"` {
+		t.Fatalf("removed user: got %q", got)
+	}
+	// Отписка не должна приходить клиентам пустым кадром
+	for _, want := range []string{`"removed"`, `"after"`} {
+		if got := waitPayload(t, other); got != want {
+			t.Fatalf("other user: got %q, want %q", got, want)
+		}
+	}
+
+	select {
+	case p := <-removed.send:
+		t.Fatalf("removed user got %q after unsubscribe", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHub_ReordersEventsBySeq(t *testing.T) {
+	h := NewHub(NewMemoryBackend(), slog.New(slog.DiscardHandler))
+	go h.Run()
+
+	c := NewConnection(nil, 1, 0)
+	h.Register(c)
+	h.Subscribe(c, []int64{10})
+
+	// seq 3 пришёл раньше 2, отписка — пока 2 ещё ждём
+	h.BroadcastSeq(10, 1, []byte("1"))
+	h.BroadcastSeq(10, 3, []byte("3"))
+	h.UnsubscribeUsers(10, []int64{1})
+	h.BroadcastSeq(10, 2, []byte("2"))
+
+	for _, want := range []string{"1", "2", "3"} {
+		if got := waitPayload(t, c); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+
+	h.BroadcastSeq(10, 4, []byte("4"))
+	select {
+	case p := <-c.send:
+		t.Fatalf("got %q after unsubscribe", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHub_GapIsFlushedAfterWait(t *testing.T) {
+	h := NewHub(NewMemoryBackend(), slog.New(slog.DiscardHandler))
+	go h.Run()
+
+	c := NewConnection(nil, 1, 0)
+	h.Register(c)
+	h.Subscribe(c, []int64{10})
+
+	h.BroadcastSeq(10, 1, []byte("1"))
+	h.BroadcastSeq(10, 3, []byte("3"))
+
+	if got := waitPayload(t, c); got != "1" {
+		t.Fatalf("got %q, want 1", got)
+	}
+
+	select {
+	case p := <-c.send:
+		t.Fatalf("got %q before the gap timed out", p)
+	case <-time.After(reorderWait / 2):
+	}
+
+	select {
+	case p := <-c.send:
+		if string(p) != "3" {
+			t.Fatalf("got %q, want 3", p)
+		}
+	case <-time.After(2 * reorderWait):
+		t.Fatal("event after the gap was never delivered")
+	}
+}
+
+func waitConnections(t *testing.T, h *Hub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if len(h.Stats()) >= n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("hub connections: want %d", n)
+}
+
+func isRevoked(c *Connection, within time.Duration) bool {
+	select {
+	case <-c.Revoked():
+		return true
+	case <-time.After(within):
+		return false
+	}
+}
+
+func TestHub_CloseSessionAcrossInstances(t *testing.T) {
+	backend := NewMemoryBackend()
+	log := slog.New(slog.DiscardHandler)
+
+	a := NewHub(backend, log)
+	b := NewHub(backend, log)
+	go a.Run()
+	go b.Run()
+	waitListeners(t, backend, 2)
+
+	local := NewConnection(nil, 1, 5)
+	signedOut := NewConnection(nil, 1, 5)
+	otherSession := NewConnection(nil, 1, 6)
+	otherUser := NewConnection(nil, 2, 7)
+	a.Register(local)
+	b.Register(signedOut)
+	b.Register(otherSession)
+	b.Register(otherUser)
+	waitConnections(t, a, 1)
+	waitConnections(t, b, 3)
+
+	a.CloseSession(1, 5)
+
+	if !isRevoked(local, time.Second) {
+		t.Error("local connection of the revoked session is still open")
+	}
+	if !isRevoked(signedOut, time.Second) {
+		t.Error("remote connection of the revoked session is still open")
+	}
+	if isRevoked(otherSession, 50*time.Millisecond) {
+		t.Error("CloseSession closed another session of the same user")
+	}
+
+	// Отозванному соединению события больше не доставляются
+	signedOut.Send([]byte("after revoke"))
+	select {
+	case p := <-signedOut.send:
+		t.Errorf("revoked connection got %q", p)
+	default:
+	}
+
+	a.CloseUser(1)
+
+	if !isRevoked(otherSession, time.Second) {
+		t.Error("CloseUser left a connection of the user open")
+	}
+	if isRevoked(otherUser, 50*time.Millisecond) {
+		t.Error("CloseUser closed a connection of another user")
+	}
+}
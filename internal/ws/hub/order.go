@@ -0,0 +1,129 @@
+package hub
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// reorderWait — сколько хаб ждёт пропущенный seq, прежде чем отдать более
+// поздние события чата без него. Дыра остаётся, если событие потерялось по
+// дороге от другого инстанса; клиент увидит её по seq и догонит через since_seq.
+const reorderWait = time.Second
+
+// chatOrder восстанавливает порядок событий чата по seq: инстансы публикуют
+// их независимо, и соседние seq могут прийти в обратном порядке. Трогает
+// только Run.
+type chatOrder struct {
+	// last — последний отданный seq; 0 — хаб ещё не видел событий чата
+	last    int64
+	pending map[int64]BroadcastCmd
+	// after — отписки, пришедшие, пока ждём дыру: их выполняют после
+	// придержанных событий, чтобы исключённый получил событие об исключении
+	after        []BroadcastCmd
+	waitingSince time.Time
+}
+
+// route доставляет команду чата сразу или придерживает её до прихода
+// предыдущих seq.
+func (h *Hub) route(b BroadcastCmd) {
+	o := h.order[b.ChatID]
+	if o == nil {
+		if h.chats[b.ChatID] == nil {
+			return
+		}
+		o = &chatOrder{pending: make(map[int64]BroadcastCmd)}
+		h.order[b.ChatID] = o
+	}
+
+	if b.Seq == 0 {
+		if len(o.pending) > 0 && len(b.DropUsers) > 0 {
+			o.after = append(o.after, b)
+			return
+		}
+		h.deliverRoom(b)
+		return
+	}
+
+	// Старые seq тоже отдаём: соединение в replay могло их ещё не видеть,
+	// дубли отбросит Connection.deliver
+	if o.last == 0 || b.Seq <= o.last+1 {
+		h.deliverRoom(b)
+		o.last = max(o.last, b.Seq)
+		h.drain(b.ChatID, o)
+		return
+	}
+
+	if len(o.pending) == 0 {
+		o.waitingSince = time.Now()
+	}
+	o.pending[b.Seq] = b
+}
+
+// drain отдаёт придержанные события, которые теперь идут подряд.
+func (h *Hub) drain(chatID int64, o *chatOrder) {
+	for {
+		b, ok := o.pending[o.last+1]
+		if !ok {
+			break
+		}
+		delete(o.pending, b.Seq)
+		h.deliverRoom(b)
+		o.last = b.Seq
+	}
+
+	if len(o.pending) > 0 {
+		o.waitingSince = time.Now()
+		return
+	}
+	h.finishOrder(chatID, o)
+}
+
+// flushStale отдаёт события чатов, которые ждут дыру дольше reorderWait.
+func (h *Hub) flushStale(now time.Time) {
+	for chatID, o := range h.order {
+		if len(o.pending) == 0 || now.Sub(o.waitingSince) < reorderWait {
+			continue
+		}
+
+		seqs := make([]int64, 0, len(o.pending))
+		for seq := range o.pending {
+			seqs = append(seqs, seq)
+		}
+		slices.Sort(seqs)
+
+		h.log.Warn("ws chat events gap, delivering without missing seq",
+			slog.Int64("chat_id", chatID),
+			slog.Int64("after_seq", o.last),
+			slog.Int64("next_seq", seqs[0]),
+		)
+
+		for _, seq := range seqs {
+			h.deliverRoom(o.pending[seq])
+			delete(o.pending, seq)
+		}
+		o.last = seqs[len(seqs)-1]
+		h.finishOrder(chatID, o)
+	}
+}
+
+func (h *Hub) finishOrder(chatID int64, o *chatOrder) {
+	after := o.after
+	o.after = nil
+	for _, b := range after {
+		h.deliverRoom(b)
+	}
+
+	if h.chats[chatID] == nil {
+		delete(h.order, chatID)
+	}
+}
+
+// dropRoom удаляет пустую комнату чата; порядок seq без подписчиков не нужен,
+// если ничего не придержано.
+func (h *Hub) dropRoom(chatID int64) {
+	delete(h.chats, chatID)
+	if o := h.order[chatID]; o != nil && len(o.pending) == 0 && len(o.after) == 0 {
+		delete(h.order, chatID)
+	}
+}
@@ -17,6 +17,11 @@ func (c *Connection) WritePump() {
 
 	for {
 		select {
+		case <-c.backlogReady:
+			if !c.writeBacklog() {
+				return
+			}
+
 		case msg, ok := <-c.send:
 			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
@@ -24,10 +29,30 @@ func (c *Connection) WritePump() {
 				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
+			// Replay, поставленный раньше msg, должен уйти раньше него
+			if !c.writeBacklog() {
+				return
+			}
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
 				return
 			}
 
+		case <-c.lagging:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			_ = c.conn.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(CloseLagging, "send buffer overflow, resync required"))
+			// Закрываем сокет, чтобы read-цикл хендлера тоже завершился
+			_ = c.conn.Close()
+			return
+
+		case <-c.revoked:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			_ = c.conn.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(CloseSessionRevoked, "session revoked"))
+			_ = c.conn.Close()
+			return
+
 		case <-ticker.C:
 			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -36,3 +61,13 @@ func (c *Connection) WritePump() {
 		}
 	}
 }
+
+func (c *Connection) writeBacklog() bool {
+	for _, msg := range c.takeBacklog() {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			return false
+		}
+	}
+	return true
+}
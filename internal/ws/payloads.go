@@ -1,6 +1,12 @@
 package ws
 
-import "github.com/kgellert/hodatay-messenger/internal/messages"
+import (
+	"time"
+
+	"github.com/kgellert/hodatay-messenger/internal/chats"
+	"github.com/kgellert/hodatay-messenger/internal/messages"
+	"github.com/kgellert/hodatay-messenger/internal/users"
+)
 
 type MessagesDeletePayload struct {
 	IDs []int64 `json:"ids"`
@@ -10,6 +16,68 @@ type MessageNewPayload struct {
 	Message messages.Message `json:"message"`
 }
 
+type MessageEditedPayload struct {
+	Message messages.Message `json:"message"`
+}
+
+type ReactionPayload struct {
+	MessageID int64  `json:"message_id"`
+	UserID    int64  `json:"user_id"`
+	Emoji     string `json:"emoji"`
+}
+
+type MessagePinnedPayload struct {
+	Pin messages.Pin `json:"pin"`
+}
+
+// MessageUnpinnedPayload приходит и когда закреп снят удалением сообщения
+// у всех — сразу после message.deleted.
+type MessageUnpinnedPayload struct {
+	MessageID int64 `json:"message_id"`
+	ActorID   int64 `json:"actor_id"`
+}
+
+type TypingPayload struct {
+	UserID int64 `json:"user_id"`
+	// ExpiresInMs: через сколько клиенту считать typing.stop, если не придёт новый typing.start
+	ExpiresInMs int64 `json:"expires_in_ms,omitempty"`
+}
+
+type PresencePayload struct {
+	UserID     int64      `json:"user_id"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+}
+
+type ChatMemberAddedPayload struct {
+	ActorID int64        `json:"actor_id"`
+	Users   []users.User `json:"users"`
+}
+
+// ChatMemberRemovedPayload: ActorID == UserID — участник вышел сам.
+type ChatMemberRemovedPayload struct {
+	ActorID int64 `json:"actor_id"`
+	UserID  int64 `json:"user_id"`
+	// NewOwnerID — кому перешло владение, если вышел владелец
+	NewOwnerID int64 `json:"new_owner_id,omitempty"`
+}
+
+// ChatUpdatedPayload несёт оформление и поручение чата целиком, а не только
+// изменённые поля.
+type ChatUpdatedPayload struct {
+	ActorID int64 `json:"actor_id"`
+	chats.ChatUpdate
+}
+
+type ChatSettingsUpdatedPayload struct {
+	Settings chats.ChatSettings `json:"settings"`
+}
+
+type ResyncRequiredPayload struct {
+	// Seq — текущий номер события чата: после перезагрузки чата по HTTP
+	// клиент продолжает с него
+	Seq int64 `json:"seq"`
+}
+
 type MessageReadPayload struct {
 	UserID                   		int64 `json:"user_id"`
 	LastReadMessageID        		int64 `json:"last_read_message_id"`
@@ -0,0 +1,146 @@
+// Package pgnotify — hub.Backend поверх Postgres LISTEN/NOTIFY, чтобы
+// несколько реплик мессенджера видели события друг друга.
+package pgnotify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/kgellert/hodatay-messenger/internal/logger/sl"
+	"github.com/kgellert/hodatay-messenger/internal/ws/hub"
+)
+
+const (
+	channel = "ws_events"
+
+	// У NOTIFY лимит payload 8000 байт. Всё, что больше, кладём в ws_outbox
+	// и отправляем только id строки.
+	maxInlinePayload = 7500
+
+	outboxRetention = "5 minutes"
+)
+
+// notice — то, что реально уходит в NOTIFY: либо сам конверт, либо ссылка на ws_outbox.
+type notice struct {
+	Envelope *hub.Envelope `json:"e,omitempty"`
+	Ref      int64         `json:"ref,omitempty"`
+}
+
+type Backend struct {
+	db  *sqlx.DB
+	dsn string
+	log *slog.Logger
+}
+
+// New: db используется для NOTIFY и ws_outbox, по dsn открывается отдельное
+// соединение под LISTEN (из пула его брать нельзя).
+func New(db *sqlx.DB, dsn string, log *slog.Logger) *Backend {
+	return &Backend{
+		db:  db,
+		dsn: dsn,
+		log: log.With(slog.String("component", "ws/pgnotify")),
+	}
+}
+
+func (b *Backend) Publish(ctx context.Context, e hub.Envelope) error {
+	const op = "ws.pgnotify.Publish"
+
+	body, err := json.Marshal(notice{Envelope: &e})
+	if err != nil {
+		return fmt.Errorf("%s: marshal: %w", op, err)
+	}
+
+	if len(body) > maxInlinePayload {
+		body, err = b.storeLarge(ctx, e)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if _, err := b.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, channel, string(body)); err != nil {
+		return fmt.Errorf("%s: notify: %w", op, err)
+	}
+
+	return nil
+}
+
+func (b *Backend) storeLarge(ctx context.Context, e hub.Envelope) ([]byte, error) {
+	envelope, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("marshal envelope: %w", err)
+	}
+
+	var id int64
+	if err := b.db.GetContext(ctx, &id, `
+		INSERT INTO ws_outbox (payload) VALUES ($1) RETURNING id
+	`, envelope); err != nil {
+		return nil, fmt.Errorf("insert outbox: %w", err)
+	}
+
+	// Все реплики получают NOTIFY практически сразу, старые строки больше не нужны
+	if _, err := b.db.ExecContext(ctx, `
+		DELETE FROM ws_outbox WHERE created_at < now() - $1::interval
+	`, outboxRetention); err != nil {
+		b.log.Warn("failed to clean ws_outbox", sl.Err(err))
+	}
+
+	return json.Marshal(notice{Ref: id})
+}
+
+func (b *Backend) Listen(ctx context.Context, deliver func(hub.Envelope)) error {
+	const op = "ws.pgnotify.Listen"
+
+	conn, err := pgx.Connect(ctx, b.dsn)
+	if err != nil {
+		return fmt.Errorf("%s: connect: %w", op, err)
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+channel); err != nil {
+		return fmt.Errorf("%s: listen: %w", op, err)
+	}
+
+	b.log.Info("listening for ws events", slog.String("channel", channel))
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: wait: %w", op, err)
+		}
+
+		e, err := b.decode(ctx, n.Payload)
+		if err != nil {
+			b.log.Error("failed to decode ws notification", sl.Err(err))
+			continue
+		}
+
+		deliver(e)
+	}
+}
+
+func (b *Backend) decode(ctx context.Context, payload string) (hub.Envelope, error) {
+	var n notice
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		return hub.Envelope{}, fmt.Errorf("unmarshal notice: %w", err)
+	}
+
+	if n.Envelope != nil {
+		return *n.Envelope, nil
+	}
+
+	var raw []byte
+	if err := b.db.GetContext(ctx, &raw, `SELECT payload FROM ws_outbox WHERE id = $1`, n.Ref); err != nil {
+		return hub.Envelope{}, fmt.Errorf("select outbox %d: %w", n.Ref, err)
+	}
+
+	var e hub.Envelope
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return hub.Envelope{}, fmt.Errorf("unmarshal outbox %d: %w", n.Ref, err)
+	}
+
+	return e, nil
+}